@@ -14,9 +14,12 @@ func main() {
 	}
 
 	commands := map[string]func([]string){
+		"login":       cli.LoginCmd,
+		"logout":      cli.LogoutCmd,
 		"user":        cli.UserCmd,
 		"chat":        cli.ChatCmd,
 		"msg":         cli.MsgCmd,
+		"dm":          cli.DmCmd,
 		"interactive": interactiveCmd,
 	}
 
@@ -29,15 +32,18 @@ func main() {
 }
 
 func interactiveCmd(args []string) {
-	if len(args) < 2 {
-		fmt.Println("Usage: cligram interactive <user_id> <server_addr>")
+	if len(args) > 1 {
+		fmt.Println("Usage: cligram interactive [server_addr]")
 		return
 	}
-	userID := args[0]
-	serverAddr := args[1]
-	cli.InteractiveChat(userID, serverAddr)
+	// the server defaults to the one logged in to
+	serverAddr := ""
+	if len(args) == 1 {
+		serverAddr = args[0]
+	}
+	cli.InteractiveChat(serverAddr)
 }
 
 func printUsage() {
-	fmt.Println("Usage: cligram <user|chat|msg|interactive> ...")
+	fmt.Println("Usage: cligram <login|logout|user|chat|msg|dm|interactive> ...")
 }
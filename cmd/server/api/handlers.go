@@ -3,17 +3,23 @@ package api
 import (
 	"cligram/cmd/server/types"
 	"cligram/internal/app"
+	"cligram/internal/domain"
+	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"strconv"
 
 	"github.com/gorilla/mux"
 )
 
 type Server struct {
 	Service *app.ChatService
+	Auth    *app.AuthService
 }
 
+// CreateUserHandler registers a user with a password; it needs no token
 func (s *Server) CreateUserHandler(w http.ResponseWriter, r *http.Request) {
 	var req types.CreateUserRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -23,9 +29,9 @@ func (s *Server) CreateUserHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	log.Printf("CreateUserHandler: creating user %s", req.ID)
-	if err := s.Service.CreateUser(req.ID, req.Name); err != nil {
+	if err := s.Auth.Register(r.Context(), req.ID, req.Name, req.Password); err != nil {
 		log.Printf("CreateUserHandler error: %v", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), httpStatus(err))
 		return
 	}
 
@@ -33,7 +39,42 @@ func (s *Server) CreateUserHandler(w http.ResponseWriter, r *http.Request) {
 	log.Printf("CreateUserHandler: user %s created successfully", req.ID)
 }
 
+// LoginHandler exchanges a user ID and password for a bearer token
+func (s *Server) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	var req types.LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("LoginHandler decode error: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	token, expiresAt, err := s.Auth.Login(r.Context(), req.UserID, req.Password)
+	if err != nil {
+		log.Printf("LoginHandler: login of %s failed: %v", req.UserID, err)
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(types.LoginResponse{UserID: req.UserID, Token: token, ExpiresAt: expiresAt})
+	log.Printf("LoginHandler: user %s logged in", req.UserID)
+}
+
+// LogoutHandler revokes the token the request was made with
+func (s *Server) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if err := s.Auth.Logout(r.Context(), bearerToken(r)); err != nil {
+		log.Printf("LogoutHandler error: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	log.Printf("LogoutHandler: user %s logged out", currentUser(r))
+}
+
 func (s *Server) CreateChatHandler(w http.ResponseWriter, r *http.Request) {
+	userID := currentUser(r)
+
 	var req types.CreateChatRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		log.Printf("CreateChatHandler decode error: %v", err)
@@ -42,17 +83,60 @@ func (s *Server) CreateChatHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	log.Printf("CreateChatHandler: creating chat %s with members %v", req.ID, req.Members)
-	if err := s.Service.CreateChat(req.ID, req.Members); err != nil {
+	chat, err := s.Service.CreateChat(r.Context(), userID, domain.Chat{
+		ID:          req.ID,
+		Name:        req.Name,
+		Description: req.Description,
+		Members:     req.Members,
+	})
+	if err != nil {
 		log.Printf("CreateChatHandler error: %v", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), httpStatus(err))
 		return
 	}
 
 	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(chat); err != nil {
+		log.Printf("CreateChatHandler encode error: %v", err)
+		return
+	}
+
 	log.Printf("CreateChatHandler: chat %s created successfully", req.ID)
 }
 
+// DirectChatHandler finds or creates the direct chat between two users
+func (s *Server) DirectChatHandler(w http.ResponseWriter, r *http.Request) {
+	userID := currentUser(r)
+
+	var req types.DirectChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("DirectChatHandler decode error: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("DirectChatHandler: direct chat between %s and %s", userID, req.OtherID)
+	chat, created, err := s.Service.DirectChat(r.Context(), userID, req.OtherID)
+	if err != nil {
+		log.Printf("DirectChatHandler error: %v", err)
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+
+	if created {
+		w.WriteHeader(http.StatusCreated)
+	}
+	if err := json.NewEncoder(w).Encode(chat); err != nil {
+		log.Printf("DirectChatHandler encode error: %v", err)
+		return
+	}
+
+	log.Printf("DirectChatHandler: direct chat %s (created: %t)", chat.ID, created)
+}
+
 func (s *Server) SendMessageHandler(w http.ResponseWriter, r *http.Request) {
+	userID := currentUser(r)
+
 	var req types.SendMessageRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		log.Printf("SendMessageHandler decode error: %v", err)
@@ -60,32 +144,67 @@ func (s *Server) SendMessageHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Printf("SendMessageHandler: sending message from %s to chat %s", req.From, req.ChatID)
-	if err := s.Service.SendMessage(req.From, req.ChatID, req.Text); err != nil {
+	log.Printf("SendMessageHandler: sending message from %s to chat %s", userID, req.ChatID)
+	var msg domain.Message
+	var err error
+	if req.ReplyTo != "" {
+		msg, err = s.Service.ReplyToMessage(r.Context(), userID, req.ChatID, req.ReplyTo, req.Text, req.ClientID)
+	} else {
+		msg, err = s.Service.SendMessage(r.Context(), userID, req.ChatID, req.Text, req.ClientID)
+	}
+	if err != nil {
 		log.Printf("SendMessageHandler error: %v", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), httpStatus(err))
 		return
 	}
 
 	w.WriteHeader(http.StatusCreated)
-	log.Printf("SendMessageHandler: message sent successfully from %s to chat %s", req.From, req.ChatID)
+	if err := json.NewEncoder(w).Encode(msg); err != nil {
+		log.Printf("SendMessageHandler encode error: %v", err)
+		return
+	}
+
+	log.Printf("SendMessageHandler: message %s sent successfully from %s to chat %s", msg.ID, userID, msg.ChatID)
 }
 
 func (s *Server) ListMessagesHandler(w http.ResponseWriter, r *http.Request) {
-	userID := r.URL.Query().Get("user_id")
+	userID := currentUser(r)
 	chatID := r.URL.Query().Get("chat_id")
 
-	if userID == "" || chatID == "" {
-		log.Printf("ListMessagesHandler missing parameters: user_id=%s chat_id=%s", userID, chatID)
-		http.Error(w, "user_id and chat_id are required", http.StatusBadRequest)
+	if chatID == "" {
+		log.Printf("ListMessagesHandler missing chat_id parameter")
+		http.Error(w, "chat_id is required", http.StatusBadRequest)
 		return
 	}
 
+	page := domain.MessagePage{
+		Before: r.URL.Query().Get("before"),
+		After:  r.URL.Query().Get("after"),
+	}
+	if limit := r.URL.Query().Get("limit"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil {
+			log.Printf("ListMessagesHandler invalid limit: %s", limit)
+			http.Error(w, "limit must be a number", http.StatusBadRequest)
+			return
+		}
+		page.Limit = l
+	}
+	if afterSeq := r.URL.Query().Get("after_seq"); afterSeq != "" {
+		seq, err := strconv.ParseInt(afterSeq, 10, 64)
+		if err != nil {
+			log.Printf("ListMessagesHandler invalid after_seq: %s", afterSeq)
+			http.Error(w, "after_seq must be a number", http.StatusBadRequest)
+			return
+		}
+		page.AfterSeq = &seq
+	}
+
 	log.Printf("ListMessagesHandler: listing messages for user %s in chat %s", userID, chatID)
-	msgs, err := s.Service.ListMessages(userID, chatID)
+	msgs, err := s.Service.ListMessages(r.Context(), userID, chatID, page)
 	if err != nil {
 		log.Printf("ListMessagesHandler error: %v", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), httpStatus(err))
 		return
 	}
 
@@ -99,18 +218,13 @@ func (s *Server) ListMessagesHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) ListChatsHandler(w http.ResponseWriter, r *http.Request) {
-	userID := r.URL.Query().Get("user_id")
-	if userID == "" {
-		log.Printf("ListChatsHandler missing user_id parameter")
-		http.Error(w, "user_id is required", http.StatusBadRequest)
-		return
-	}
+	userID := currentUser(r)
 
 	log.Printf("ListChatsHandler: listing chats for user %s", userID)
-	chats, err := s.Service.ListUserChats(userID)
+	chats, err := s.Service.ListUserChatsWithUnread(r.Context(), userID)
 	if err != nil {
 		log.Printf("ListChatsHandler error: %v", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), httpStatus(err))
 		return
 	}
 
@@ -126,6 +240,7 @@ func (s *Server) ListChatsHandler(w http.ResponseWriter, r *http.Request) {
 func (s *Server) GetChatHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	chatID := vars["id"]
+	userID := currentUser(r)
 	
 	if chatID == "" {
 		log.Printf("GetChatHandler missing chat_id in path")
@@ -133,11 +248,11 @@ func (s *Server) GetChatHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Printf("GetChatHandler: getting chat %s", chatID)
-	chat, err := s.Service.GetChatByID(chatID)
+	log.Printf("GetChatHandler: user %s getting chat %s", userID, chatID)
+	chat, err := s.Service.GetChat(r.Context(), userID, chatID)
 	if err != nil {
 		log.Printf("GetChatHandler error: %v", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), httpStatus(err))
 		return
 	}
 
@@ -149,3 +264,413 @@ func (s *Server) GetChatHandler(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("GetChatHandler: returned chat %s", chatID)
 }
+
+func (s *Server) SearchMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	userID := currentUser(r)
+	query := r.URL.Query().Get("q")
+
+	if query == "" {
+		log.Printf("SearchMessagesHandler missing q parameter")
+		http.Error(w, "q is required", http.StatusBadRequest)
+		return
+	}
+
+	limit := 0
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil {
+			log.Printf("SearchMessagesHandler invalid limit: %s", l)
+			http.Error(w, "limit must be a number", http.StatusBadRequest)
+			return
+		}
+	}
+
+	log.Printf("SearchMessagesHandler: searching %q for user %s", query, userID)
+	msgs, err := s.Service.SearchMessages(r.Context(), userID, query, limit)
+	if err != nil {
+		log.Printf("SearchMessagesHandler error: %v", err)
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+
+	if msgs == nil {
+		msgs = []domain.Message{}
+	}
+	if err := json.NewEncoder(w).Encode(msgs); err != nil {
+		log.Printf("SearchMessagesHandler encode error: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("SearchMessagesHandler: returned %d messages for user %s", len(msgs), userID)
+}
+
+// httpStatus maps a service error to the status code to reply with. Errors
+// it does not know are server faults, which clients may retry.
+func httpStatus(err error) int {
+	switch {
+	case errors.Is(err, domain.ErrInvalidInput),
+		errors.Is(err, domain.ErrDirectChat):
+		return http.StatusBadRequest
+	case errors.Is(err, domain.ErrUserNotFound),
+		errors.Is(err, domain.ErrChatNotFound),
+		errors.Is(err, domain.ErrMessageNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, domain.ErrInvalidCredentials),
+		errors.Is(err, domain.ErrUnauthenticated):
+		return http.StatusUnauthorized
+	case errors.Is(err, domain.ErrUserNotInChat),
+		errors.Is(err, domain.ErrNotMessageOwner),
+		errors.Is(err, domain.ErrNotAllowed),
+		errors.Is(err, domain.ErrUserBanned):
+		return http.StatusForbidden
+	case errors.Is(err, domain.ErrAlreadyMember),
+		errors.Is(err, domain.ErrChatExists),
+		errors.Is(err, domain.ErrUserExists),
+		errors.Is(err, domain.ErrClientIDReused),
+		errors.Is(err, domain.ErrChatChanged):
+		return http.StatusConflict
+	case errors.Is(err, domain.ErrMessageDeleted):
+		return http.StatusGone
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, context.Canceled):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (s *Server) EditMessageHandler(w http.ResponseWriter, r *http.Request) {
+	messageID := mux.Vars(r)["id"]
+	userID := currentUser(r)
+
+	var req types.EditMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("EditMessageHandler decode error: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("EditMessageHandler: user %s editing message %s", userID, messageID)
+	msg, err := s.Service.EditMessage(r.Context(), userID, messageID, req.Text)
+	if err != nil {
+		log.Printf("EditMessageHandler error: %v", err)
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(msg); err != nil {
+		log.Printf("EditMessageHandler encode error: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("EditMessageHandler: message %s edited", messageID)
+}
+
+func (s *Server) DeleteMessageHandler(w http.ResponseWriter, r *http.Request) {
+	messageID := mux.Vars(r)["id"]
+	userID := currentUser(r)
+
+	log.Printf("DeleteMessageHandler: user %s deleting message %s", userID, messageID)
+	if _, err := s.Service.DeleteMessage(r.Context(), userID, messageID); err != nil {
+		log.Printf("DeleteMessageHandler error: %v", err)
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	log.Printf("DeleteMessageHandler: message %s deleted", messageID)
+}
+
+func (s *Server) MessageHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	messageID := mux.Vars(r)["id"]
+	userID := currentUser(r)
+
+	log.Printf("MessageHistoryHandler: user %s reading history of message %s", userID, messageID)
+	revisions, err := s.Service.MessageHistory(r.Context(), userID, messageID)
+	if err != nil {
+		log.Printf("MessageHistoryHandler error: %v", err)
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(revisions); err != nil {
+		log.Printf("MessageHistoryHandler encode error: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("MessageHistoryHandler: returned %d revisions of message %s", len(revisions), messageID)
+}
+
+func (s *Server) AddReactionHandler(w http.ResponseWriter, r *http.Request) {
+	messageID := mux.Vars(r)["id"]
+	userID := currentUser(r)
+
+	var req types.ReactionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("AddReactionHandler decode error: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("AddReactionHandler: user %s reacting %s to message %s", userID, req.Emoji, messageID)
+	msg, added, err := s.Service.AddReaction(r.Context(), userID, messageID, req.Emoji)
+	if err != nil {
+		log.Printf("AddReactionHandler error: %v", err)
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+
+	// reacting again takes the reaction back, which creates nothing
+	status := http.StatusOK
+	if added {
+		status = http.StatusCreated
+	}
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(msg.ReactionCounts()); err != nil {
+		log.Printf("AddReactionHandler encode error: %v", err)
+		return
+	}
+
+	log.Printf("AddReactionHandler: reaction added to message %s: %v", messageID, added)
+}
+
+func (s *Server) RemoveReactionHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	messageID := vars["id"]
+	emoji := vars["emoji"]
+	userID := currentUser(r)
+
+	log.Printf("RemoveReactionHandler: user %s removing %s from message %s", userID, emoji, messageID)
+	if _, err := s.Service.RemoveReaction(r.Context(), userID, messageID, emoji); err != nil {
+		log.Printf("RemoveReactionHandler error: %v", err)
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	log.Printf("RemoveReactionHandler: reaction removed from message %s", messageID)
+}
+
+func (s *Server) ListReactionsHandler(w http.ResponseWriter, r *http.Request) {
+	messageID := mux.Vars(r)["id"]
+	userID := currentUser(r)
+
+	log.Printf("ListReactionsHandler: listing reactions to message %s for user %s", messageID, userID)
+	counts, err := s.Service.ListReactions(r.Context(), userID, messageID)
+	if err != nil {
+		log.Printf("ListReactionsHandler error: %v", err)
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+
+	if counts == nil {
+		counts = []domain.ReactionCount{}
+	}
+	if err := json.NewEncoder(w).Encode(counts); err != nil {
+		log.Printf("ListReactionsHandler encode error: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("ListReactionsHandler: returned %d reactions to message %s", len(counts), messageID)
+}
+
+func (s *Server) ThreadHandler(w http.ResponseWriter, r *http.Request) {
+	messageID := mux.Vars(r)["id"]
+	userID := currentUser(r)
+
+	log.Printf("ThreadHandler: getting thread of message %s for user %s", messageID, userID)
+	msgs, err := s.Service.Thread(r.Context(), userID, messageID)
+	if err != nil {
+		log.Printf("ThreadHandler error: %v", err)
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(msgs); err != nil {
+		log.Printf("ThreadHandler encode error: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("ThreadHandler: returned %d messages of thread %s", len(msgs), messageID)
+}
+
+func (s *Server) AddMemberHandler(w http.ResponseWriter, r *http.Request) {
+	chatID := mux.Vars(r)["id"]
+	userID := currentUser(r)
+
+	var req types.AddMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("AddMemberHandler decode error: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("AddMemberHandler: user %s adding %s to chat %s", userID, req.MemberID, chatID)
+	chat, err := s.Service.AddMember(r.Context(), userID, chatID, req.MemberID)
+	if err != nil {
+		log.Printf("AddMemberHandler error: %v", err)
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(chat); err != nil {
+		log.Printf("AddMemberHandler encode error: %v", err)
+		return
+	}
+
+	log.Printf("AddMemberHandler: %s added to chat %s", req.MemberID, chatID)
+}
+
+// RemoveMemberHandler removes a member from a chat; with ban=true they are
+// kicked, and removing yourself leaves the chat
+func (s *Server) RemoveMemberHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	chatID := vars["id"]
+	memberID := vars["member"]
+	userID := currentUser(r)
+	ban := r.URL.Query().Get("ban") == "true"
+
+	var err error
+	if memberID == userID && !ban {
+		log.Printf("RemoveMemberHandler: user %s leaving chat %s", userID, chatID)
+		err = s.Service.LeaveChat(r.Context(), userID, chatID)
+	} else {
+		log.Printf("RemoveMemberHandler: user %s removing %s from chat %s (ban: %t)", userID, memberID, chatID, ban)
+		_, err = s.Service.RemoveMember(r.Context(), userID, chatID, memberID, ban)
+	}
+	if err != nil {
+		log.Printf("RemoveMemberHandler error: %v", err)
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	log.Printf("RemoveMemberHandler: %s removed from chat %s", memberID, chatID)
+}
+
+func (s *Server) SetMemberRoleHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	chatID := vars["id"]
+	memberID := vars["member"]
+	userID := currentUser(r)
+
+	var req types.MemberRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("SetMemberRoleHandler decode error: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("SetMemberRoleHandler: user %s making %s %s in chat %s", userID, memberID, req.Role, chatID)
+	chat, err := s.Service.SetMemberRole(r.Context(), userID, chatID, memberID, domain.ChatRole(req.Role))
+	if err != nil {
+		log.Printf("SetMemberRoleHandler error: %v", err)
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(chat); err != nil {
+		log.Printf("SetMemberRoleHandler encode error: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("SetMemberRoleHandler: %s is now %s in chat %s", memberID, req.Role, chatID)
+}
+
+func (s *Server) UnbanMemberHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	chatID := vars["id"]
+	memberID := vars["member"]
+	userID := currentUser(r)
+
+	log.Printf("UnbanMemberHandler: user %s unbanning %s in chat %s", userID, memberID, chatID)
+	if _, err := s.Service.UnbanMember(r.Context(), userID, chatID, memberID); err != nil {
+		log.Printf("UnbanMemberHandler error: %v", err)
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	log.Printf("UnbanMemberHandler: %s unbanned in chat %s", memberID, chatID)
+}
+
+func (s *Server) MarkReadHandler(w http.ResponseWriter, r *http.Request) {
+	chatID := mux.Vars(r)["id"]
+	userID := currentUser(r)
+
+	var req types.MarkReadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("MarkReadHandler decode error: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("MarkReadHandler: user %s read chat %s up to %q", userID, chatID, req.MessageID)
+	receipt, err := s.Service.MarkRead(r.Context(), userID, chatID, req.MessageID)
+	if err != nil {
+		log.Printf("MarkReadHandler error: %v", err)
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(receipt); err != nil {
+		log.Printf("MarkReadHandler encode error: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("MarkReadHandler: user %s read chat %s up to seq %d", userID, chatID, receipt.Seq)
+}
+
+func (s *Server) ReadReceiptsHandler(w http.ResponseWriter, r *http.Request) {
+	chatID := mux.Vars(r)["id"]
+	userID := currentUser(r)
+
+	log.Printf("ReadReceiptsHandler: listing read receipts of chat %s for user %s", chatID, userID)
+	receipts, err := s.Service.ReadReceipts(r.Context(), userID, chatID)
+	if err != nil {
+		log.Printf("ReadReceiptsHandler error: %v", err)
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+
+	if receipts == nil {
+		receipts = []domain.ReadReceipt{}
+	}
+	if err := json.NewEncoder(w).Encode(receipts); err != nil {
+		log.Printf("ReadReceiptsHandler encode error: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("ReadReceiptsHandler: returned %d read receipts of chat %s", len(receipts), chatID)
+}
+
+func (s *Server) PresenceHandler(w http.ResponseWriter, r *http.Request) {
+	callerID := currentUser(r)
+	userID := mux.Vars(r)["id"]
+
+	log.Printf("PresenceHandler: user %s getting presence of user %s", callerID, userID)
+	presence, err := s.Service.Presence(r.Context(), callerID, userID)
+	if err != nil {
+		log.Printf("PresenceHandler error: %v", err)
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(presence); err != nil {
+		log.Printf("PresenceHandler encode error: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("PresenceHandler: user %s online: %t", userID, presence.Online)
+}
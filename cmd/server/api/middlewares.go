@@ -1,9 +1,16 @@
 package api
 
 import (
+	"cligram/internal/app"
+	"cligram/internal/domain"
+	"context"
+	"errors"
 	"log"
 	"net/http"
+	"strings"
 	"time"
+
+	"github.com/gorilla/websocket"
 )
 
 func LoggingMiddleware(next http.Handler) http.Handler {
@@ -14,3 +21,58 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 		log.Printf("Completed %s %s in %v", r.Method, r.URL.Path, time.Since(start))
 	})
 }
+
+// TimeoutMiddleware bounds the context of every request by d. WebSocket
+// upgrades are left alone since those connections are meant to stay open.
+func TimeoutMiddleware(d time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if d <= 0 || websocket.IsWebSocketUpgrade(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+type contextKey int
+
+const userIDKey contextKey = iota
+
+// AuthMiddleware lets through only requests carrying a valid bearer token in
+// the Authorization header and records whose token it is for currentUser.
+// Browsers cannot set headers on WebSocket upgrades, so those may pass the
+// token in the access_token query parameter instead.
+func AuthMiddleware(auth *app.AuthService, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, err := auth.Authenticate(r.Context(), bearerToken(r))
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, domain.ErrUnauthenticated) {
+				status = http.StatusUnauthorized
+				w.Header().Set("WWW-Authenticate", `Bearer realm="cligram"`)
+			}
+			log.Printf("Rejected %s %s: %v", r.Method, r.URL.Path, err)
+			http.Error(w, err.Error(), status)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userIDKey, userID)))
+	})
+}
+
+func bearerToken(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return token
+	}
+	if websocket.IsWebSocketUpgrade(r) {
+		return r.URL.Query().Get("access_token")
+	}
+	return ""
+}
+
+// currentUser is the authenticated user making r, as set by AuthMiddleware
+func currentUser(r *http.Request) string {
+	userID, _ := r.Context().Value(userIDKey).(string)
+	return userID
+}
@@ -1,66 +1,394 @@
 package api
 
 import (
+	"cligram/internal/app"
 	"cligram/internal/domain"
+	"cligram/internal/protocol"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"maps"
+	"net"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 )
 
+const (
+	// writeWait bounds a single write to a client
+	writeWait = 10 * time.Second
+	// pongWait is how long a client may stay silent, pongs included, before
+	// it is considered gone
+	pongWait = 60 * time.Second
+	// pingPeriod must be shorter than pongWait so pongs arrive in time
+	pingPeriod = pongWait * 9 / 10
+	// sendQueueSize is how many events may wait for a client; one that
+	// falls further behind is disconnected
+	sendQueueSize = 256
+	// maxFrameSize is the largest frame accepted from a client
+	maxFrameSize = 64 << 10
+	// resumeWindow is how long a session survives its connection, waiting
+	// for the client to resume it
+	resumeWindow = 2 * time.Minute
+	// resumeHistorySize is how many of its latest events a session keeps for
+	// a resume; a client that missed more has to sync instead
+	resumeHistorySize = 1024
+)
+
+var (
+	errSlowConsumer     = errors.New("client is not keeping up, disconnected")
+	errConnectionClosed = errors.New("connection closed")
+	errSessionExpired   = errors.New("session expired")
+	errSessionTooOld    = errors.New("session no longer holds the missed events")
+)
+
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
 		return true // allow all origins for now
 	},
 }
 
-// ClientConnection represents a connected WebSocket client
+// ClientConnection is one client session: a device or terminal of a user,
+// with its own subscriptions. A user may have several at once. The session
+// outlives its WebSocket connection by resumeWindow, so a client that lost
+// its connection can reconnect with the resume token and pick up the events
+// it missed instead of starting over.
 type ClientConnection struct {
-	UserID string
-	Conn   *websocket.Conn
+	ID          string
+	UserID      string
+	ConnectedAt time.Time
+	RemoteAddr  string
+	UserAgent   string
+
+	// chats the session is subscribed to, guarded by the manager's Mutex
+	chats map[string]struct{}
+
+	// resumeToken lets the client take the session over from a new
+	// connection; expiry unregisters the session once it has been detached
+	// for resumeWindow. Both are guarded by the manager's Mutex.
+	resumeToken string
+	expiry      *time.Timer
+
+	// mu guards the fields below. sock is nil while the session is detached.
+	// Every event sent is numbered with the next lastEvent and kept in
+	// history, the most recent resumeHistorySize of them, for resuming.
+	mu        sync.Mutex
+	sock      *socket
+	lastEvent int64
+	history   []protocol.Envelope
+}
+
+func newClientConnection(userID string) *ClientConnection {
+	return &ClientConnection{
+		ID:          uuid.NewString(),
+		UserID:      userID,
+		resumeToken: uuid.NewString(),
+	}
+}
+
+// Send numbers an event and queues it for the client without waiting for it
+// to be written. Events sent while the session is detached are only kept for
+// a resume. A client whose queue is full is disconnected rather than allowed
+// to hold up everyone else.
+func (c *ClientConnection) Send(event protocol.Envelope) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lastEvent++
+	event.Seq = c.lastEvent
+	c.history = append(c.history, event)
+	if len(c.history) > resumeHistorySize {
+		c.history = c.history[1:]
+	}
+
+	if c.sock == nil {
+		return nil
+	}
+	return c.sock.queue(event)
+}
+
+// socket is the WebSocket connection a session is attached to
+type socket struct {
+	conn *websocket.Conn
+
+	// send queues events for writePump, the only goroutine writing to conn;
+	// closed is closed once the connection is done for
+	send      chan protocol.Envelope
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (s *socket) queue(event protocol.Envelope) error {
+	select {
+	case <-s.closed:
+		return errConnectionClosed
+	default:
+	}
+
+	select {
+	case s.send <- event:
+		return nil
+	default:
+		s.close()
+		return errSlowConsumer
+	}
+}
+
+// close shuts the connection down; the read loop in HandleWS then fails and
+// detaches the session
+func (s *socket) close() {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		s.conn.Close()
+	})
 }
 
-// WSManager keeps track of connected clients and their chat subscriptions
+// writePump writes queued events to the client and pings it every
+// pingPeriod, until the connection is closed
+func (s *socket) writePump(client *ClientConnection) {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event := <-s.send:
+			s.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := s.conn.WriteJSON(event); err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					log.Printf("Error sending %s to %s (%s): %v", event.Type, client.UserID, client.ID, err)
+				}
+				s.close()
+				return
+			}
+		case <-ticker.C:
+			if err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					log.Printf("Error pinging %s (%s): %v", client.UserID, client.ID, err)
+				}
+				s.close()
+				return
+			}
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+// ConnectionInfo describes a live connection for GET /users/{id}/connections
+type ConnectionInfo struct {
+	ID          string    `json:"id"`
+	ConnectedAt time.Time `json:"connected_at"`
+	RemoteAddr  string    `json:"remote_addr"`
+	UserAgent   string    `json:"user_agent,omitempty"`
+	Chats       []string  `json:"chats"`
+}
+
+// WSManager keeps track of client sessions and their chat subscriptions
 type WSManager struct {
-	Clients     map[string]*ClientConnection            // userID -> client
-	ChatClients map[string]map[string]*ClientConnection // chatID -> userID -> client
+	Clients     map[string]*ClientConnection            // connection ID -> client
+	UserClients map[string]map[string]*ClientConnection // userID -> connection ID -> client
+	ChatClients map[string]map[string]*ClientConnection // chatID -> connection ID -> client
 	Mutex       sync.RWMutex
+
+	resumable map[string]*ClientConnection // resume token -> client
+	members   MemberLookup
+
+	// memberCache holds the members of the chats with subscribers, so that
+	// a broadcast does not look them up every time. Member events keep it
+	// current. It has its own lock, which is taken after Mutex if both are.
+	membersMu   sync.Mutex
+	memberCache map[string]chatMembers // chatID -> members
 }
 
-// NewWSManager creates a new manager
-func NewWSManager() *WSManager {
+// chatMembers are the members of a chat as of its version
+type chatMembers struct {
+	version int64
+	members []string
+}
+
+// MemberLookup returns the current members of a chat
+type MemberLookup func(ctx context.Context, chatID string) ([]string, error)
+
+// NewWSManager creates a new manager. Events about a chat are only delivered
+// to the clients of its members, so someone removed from it hears nothing
+// more even before the event that unsubscribes them arrives, as can happen
+// when it went through another server instance. members is asked for them
+// the first time a chat with subscribers has an event; after that they are
+// taken from the member events.
+func NewWSManager(members MemberLookup) *WSManager {
 	return &WSManager{
 		Clients:     make(map[string]*ClientConnection),
+		UserClients: make(map[string]map[string]*ClientConnection),
 		ChatClients: make(map[string]map[string]*ClientConnection),
+		resumable:   make(map[string]*ClientConnection),
+		members:     members,
+		memberCache: make(map[string]chatMembers),
 	}
 }
 
-// RegisterClient adds a new client
-func (m *WSManager) RegisterClient(client *ClientConnection) {
+// RegisterClient adds a new client session and attaches it to conn
+func (m *WSManager) RegisterClient(client *ClientConnection, conn *websocket.Conn, r *http.Request) *socket {
 	m.Mutex.Lock()
 	defer m.Mutex.Unlock()
-	m.Clients[client.UserID] = client
+
+	client.chats = make(map[string]struct{})
+	m.Clients[client.ID] = client
+	if m.UserClients[client.UserID] == nil {
+		m.UserClients[client.UserID] = make(map[string]*ClientConnection)
+	}
+	m.UserClients[client.UserID][client.ID] = client
+	m.resumable[client.resumeToken] = client
+
+	sock, _ := m.attach(client, conn, r, 0, false)
+	return sock
 }
 
-// UnregisterClient removes a client from all chats
+// Resume attaches the session of userID holding resumeToken to conn, first
+// replaying the events after lastEvent. A session that is still attached is
+// taken over, since its old connection is most likely dead without the
+// server having noticed yet.
+func (m *WSManager) Resume(userID, resumeToken string, lastEvent int64, conn *websocket.Conn, r *http.Request) (*ClientConnection, *socket, error) {
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+
+	client, ok := m.resumable[resumeToken]
+	if !ok || client.UserID != userID {
+		return nil, nil, errSessionExpired
+	}
+	sock, err := m.attach(client, conn, r, lastEvent, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	return client, sock, nil
+}
+
+// attach makes conn the client's connection, replacing the previous one if
+// any. The "connected" frame goes out first, then the events after
+// lastEvent, and only then anything sent from now on. m.Mutex must be held.
+func (m *WSManager) attach(client *ClientConnection, conn *websocket.Conn, r *http.Request, lastEvent int64, resumed bool) (*socket, error) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	missed := slices.Clone(client.history)
+	if lastEvent > client.lastEvent {
+		return nil, errSessionTooOld
+	}
+	if lastEvent < client.lastEvent {
+		i := slices.IndexFunc(missed, func(e protocol.Envelope) bool { return e.Seq > lastEvent })
+		if i < 0 || missed[i].Seq != lastEvent+1 {
+			return nil, errSessionTooOld
+		}
+		missed = missed[i:]
+	} else {
+		missed = nil
+	}
+
+	if client.expiry != nil {
+		client.expiry.Stop()
+		client.expiry = nil
+	}
+	if client.sock != nil {
+		client.sock.close()
+	}
+
+	sock := &socket{
+		conn:   conn,
+		send:   make(chan protocol.Envelope, sendQueueSize+len(missed)),
+		closed: make(chan struct{}),
+	}
+	// HandleWS only upgrades connections that negotiated a version
+	version, _ := protocol.ParseSubprotocol(conn.Subprotocol())
+	sock.send <- protocol.New(protocol.TypeConnected, protocol.Connected{
+		Version:      version,
+		UserID:       client.UserID,
+		ConnectionID: client.ID,
+		ResumeToken:  client.resumeToken,
+		Resumed:      resumed,
+	})
+	for _, event := range missed {
+		sock.send <- event
+	}
+
+	client.sock = sock
+	client.ConnectedAt = time.Now()
+	client.RemoteAddr = r.RemoteAddr
+	client.UserAgent = r.UserAgent()
+	go sock.writePump(client)
+	return sock, nil
+}
+
+// Detach is called when sock, one of client's connections, is gone. Unless
+// the session has moved on to another connection already, it is kept for
+// resumeWindow and then unregistered.
+func (m *WSManager) Detach(client *ClientConnection, sock *socket) {
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	if client.sock != sock {
+		return
+	}
+	client.sock = nil
+
+	if _, ok := m.Clients[client.ID]; !ok {
+		return
+	}
+	var expiry *time.Timer
+	expiry = time.AfterFunc(resumeWindow, func() {
+		m.Mutex.Lock()
+		defer m.Mutex.Unlock()
+		// a resume in the meantime stopped or replaced the timer, but it may
+		// have fired already
+		if client.expiry == expiry {
+			m.unregister(client)
+		}
+	})
+	client.expiry = expiry
+}
+
+// UnregisterClient removes a client from all chats. The other connections
+// of the same user are left alone.
 func (m *WSManager) UnregisterClient(client *ClientConnection) {
 	m.Mutex.Lock()
 	defer m.Mutex.Unlock()
+	m.unregister(client)
+}
 
-	delete(m.Clients, client.UserID)
-	for _, clients := range m.ChatClients {
-		delete(clients, client.UserID)
+func (m *WSManager) unregister(client *ClientConnection) {
+	if client.expiry != nil {
+		client.expiry.Stop()
+		client.expiry = nil
+	}
+	delete(m.resumable, client.resumeToken)
+	delete(m.Clients, client.ID)
+	if clients := m.UserClients[client.UserID]; clients != nil {
+		delete(clients, client.ID)
+		if len(clients) == 0 {
+			delete(m.UserClients, client.UserID)
+		}
+	}
+	for chatID := range client.chats {
+		m.unsubscribe(client, chatID)
 	}
 }
 
 // SubscribeClientToChat subscribes a client to a chat
-func (m *WSManager) SubscribeClientToChat(userID, chatID string) {
+func (m *WSManager) SubscribeClientToChat(client *ClientConnection, chatID string) {
 	m.Mutex.Lock()
 	defer m.Mutex.Unlock()
 
-	client, ok := m.Clients[userID]
-	if !ok {
+	if _, ok := m.Clients[client.ID]; !ok {
 		return
 	}
 
@@ -68,102 +396,499 @@ func (m *WSManager) SubscribeClientToChat(userID, chatID string) {
 		m.ChatClients[chatID] = make(map[string]*ClientConnection)
 	}
 
-	m.ChatClients[chatID][userID] = client
+	m.ChatClients[chatID][client.ID] = client
+	client.chats[chatID] = struct{}{}
 }
 
 // UnsubscribeClientFromChat removes a client from a specific chat
-func (m *WSManager) UnsubscribeClientFromChat(userID, chatID string) {
+func (m *WSManager) UnsubscribeClientFromChat(client *ClientConnection, chatID string) {
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+	m.unsubscribe(client, chatID)
+}
+
+// UnsubscribeUserFromChat removes every connection of userID from a chat,
+// sending last to those that were subscribed to it
+func (m *WSManager) UnsubscribeUserFromChat(userID, chatID string, last protocol.Envelope) {
 	m.Mutex.Lock()
 	defer m.Mutex.Unlock()
 
+	for _, client := range m.UserClients[userID] {
+		if _, ok := client.chats[chatID]; ok {
+			deliver(client, last)
+		}
+		m.unsubscribe(client, chatID)
+	}
+}
+
+func (m *WSManager) unsubscribe(client *ClientConnection, chatID string) {
+	delete(client.chats, chatID)
 	if clients := m.ChatClients[chatID]; clients != nil {
-		delete(clients, userID)
+		delete(clients, client.ID)
 		if len(clients) == 0 {
 			delete(m.ChatClients, chatID)
+			m.membersMu.Lock()
+			delete(m.memberCache, chatID)
+			m.membersMu.Unlock()
 		}
 	}
 }
 
-// BroadcastMessage sends a message to all clients in a chat
-func (m *WSManager) BroadcastMessage(msg domain.Message) {
+// Connections lists the live connections of userID, oldest first. Detached
+// sessions waiting for a resume are left out.
+func (m *WSManager) Connections(userID string) []ConnectionInfo {
 	m.Mutex.RLock()
 	defer m.Mutex.RUnlock()
 
-	clients := m.ChatClients[msg.ChatID]
-	for _, client := range clients {
-		if err := client.Conn.WriteJSON(msg); err != nil {
-			log.Printf("Error sending message to %s: %v", client.UserID, err)
+	infos := make([]ConnectionInfo, 0, len(m.UserClients[userID]))
+	for _, client := range m.UserClients[userID] {
+		if client.sock == nil {
+			continue
+		}
+		infos = append(infos, ConnectionInfo{
+			ID:          client.ID,
+			ConnectedAt: client.ConnectedAt,
+			RemoteAddr:  client.RemoteAddr,
+			UserAgent:   client.UserAgent,
+			Chats:       slices.Sorted(maps.Keys(client.chats)),
+		})
+	}
+	slices.SortFunc(infos, func(a, b ConnectionInfo) int { return a.ConnectedAt.Compare(b.ConnectedAt) })
+	return infos
+}
+
+// HandleEvent delivers service events to the clients subscribed to their chat
+func (m *WSManager) HandleEvent(e app.Event) {
+	switch e := e.(type) {
+	case app.MessageCreated:
+		m.Broadcast(e.ChatID(), protocol.New(protocol.TypeMessage, protocol.MessageEvent{Message: e.Message}))
+	case app.MessageEdited:
+		m.Broadcast(e.ChatID(), protocol.New(protocol.TypeMessageEdited, protocol.MessageEvent{Message: e.Message}))
+	case app.MessageDeleted:
+		m.Broadcast(e.ChatID(), protocol.New(protocol.TypeMessageDeleted, protocol.MessageEvent{Message: e.Message}))
+	case app.ThreadUpdated:
+		m.Broadcast(e.ChatID(), protocol.New(protocol.TypeThreadUpdated, protocol.MessageEvent{Message: e.Root}))
+	case app.ReactionChanged:
+		m.Broadcast(e.ChatID(), protocol.New(protocol.TypeReaction, protocol.ReactionEvent{
+			Message:  e.Message,
+			Reaction: e.Reaction,
+			Removed:  e.Removed,
+		}))
+	case app.MemberAdded:
+		m.updateMembers(e.Chat)
+		m.Broadcast(e.ChatID(), protocol.New(protocol.TypeMemberAdded, protocol.MemberEvent{Chat: e.Chat, UserID: e.UserID, By: e.By}))
+	case app.MemberRemoved:
+		m.updateMembers(e.Chat)
+		event := protocol.New(protocol.TypeMemberRemoved, protocol.MemberEvent{
+			Chat:   e.Chat,
+			UserID: e.UserID,
+			By:     e.By,
+			Banned: e.Banned,
+		})
+		// they are no member anymore, so Broadcast skips them; tell them
+		// first, then none of their devices hears from the chat again
+		m.UnsubscribeUserFromChat(e.UserID, e.ChatID(), event)
+		m.Broadcast(e.ChatID(), event)
+	case app.MemberRoleChanged:
+		m.updateMembers(e.Chat)
+		m.Broadcast(e.ChatID(), protocol.New(protocol.TypeMemberRoleChanged, protocol.MemberEvent{Chat: e.Chat, UserID: e.UserID, Role: e.Role}))
+	case app.ReadReceiptChanged:
+		m.Broadcast(e.ChatID(), protocol.New(protocol.TypeRead, protocol.Receipt{Receipt: e.Receipt}))
+	case app.TypingChanged:
+		eventType := protocol.TypeTypingStop
+		if e.Typing {
+			eventType = protocol.TypeTypingStart
 		}
+		// the typist knows already
+		m.BroadcastExcept(e.ChatID(), e.UserID, protocol.New(eventType, protocol.Typing{ChatID: e.ChatID(), UserID: e.UserID}))
+	case app.PresenceChanged:
+		m.SendToUsers(e.Contacts, protocol.New(protocol.TypePresence, protocol.PresenceEvent{Presence: e.Presence}))
 	}
 }
 
+// SendToUsers sends an event to every connected client of userIDs, whatever
+// chats they are subscribed to
+func (m *WSManager) SendToUsers(userIDs []string, event protocol.Envelope) {
+	m.Mutex.RLock()
+	defer m.Mutex.RUnlock()
+
+	for _, userID := range userIDs {
+		for _, client := range m.UserClients[userID] {
+			deliver(client, event)
+		}
+	}
+}
+
+// Broadcast sends an event to all clients in a chat
+func (m *WSManager) Broadcast(chatID string, event protocol.Envelope) {
+	m.BroadcastExcept(chatID, "", event)
+}
+
+// BroadcastExcept sends an event to all clients in a chat but those of userID.
+// Clients of users who are no longer members are unsubscribed instead.
+func (m *WSManager) BroadcastExcept(chatID, userID string, event protocol.Envelope) {
+	m.Mutex.RLock()
+	subscribed := len(m.ChatClients[chatID]) > 0
+	m.Mutex.RUnlock()
+	if !subscribed {
+		return
+	}
+
+	members, err := m.chatMembers(chatID)
+	if err != nil {
+		log.Printf("Dropped %s event for chat %s, its members are unknown: %v", event.Type, chatID, err)
+		return
+	}
+
+	var left []*ClientConnection
+	m.Mutex.RLock()
+	for _, client := range m.ChatClients[chatID] {
+		switch {
+		case !slices.Contains(members, client.UserID):
+			left = append(left, client)
+		case client.UserID != userID:
+			deliver(client, event)
+		}
+	}
+	m.Mutex.RUnlock()
+	if len(left) == 0 {
+		return
+	}
+
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+	for _, client := range left {
+		// they may have been added back in the meantime
+		if cached, ok := m.cachedMembers(chatID); ok && slices.Contains(cached, client.UserID) {
+			continue
+		}
+		log.Printf("Unsubscribed %s (%s) from chat %s, which they left", client.UserID, client.ID, chatID)
+		m.unsubscribe(client, chatID)
+	}
+}
+
+// chatMembers returns the members of a chat, from the cache if it has them
+func (m *WSManager) chatMembers(chatID string) ([]string, error) {
+	if members, ok := m.cachedMembers(chatID); ok {
+		return members, nil
+	}
+	members, err := m.members(context.Background(), chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	m.membersMu.Lock()
+	defer m.membersMu.Unlock()
+	// a member event that came in during the lookup is at least as recent
+	if cached, ok := m.memberCache[chatID]; ok {
+		return cached.members, nil
+	}
+	m.memberCache[chatID] = chatMembers{members: members}
+	return members, nil
+}
+
+func (m *WSManager) cachedMembers(chatID string) ([]string, bool) {
+	m.membersMu.Lock()
+	defer m.membersMu.Unlock()
+	cached, ok := m.memberCache[chatID]
+	return cached.members, ok
+}
+
+// updateMembers caches the members of chat if it has subscribers, unless a
+// later version of it is cached already, as events from other server
+// instances may come late
+func (m *WSManager) updateMembers(chat domain.Chat) {
+	m.Mutex.RLock()
+	defer m.Mutex.RUnlock()
+	m.membersMu.Lock()
+	defer m.membersMu.Unlock()
+
+	cached, ok := m.memberCache[chat.ID]
+	if !ok && len(m.ChatClients[chat.ID]) == 0 {
+		return
+	}
+	if !ok || chat.Version >= cached.version {
+		m.memberCache[chat.ID] = chatMembers{version: chat.Version, members: slices.Clone(chat.Members)}
+	}
+}
+
+// deliver queues event for client. Clients that went away are skipped
+// silently, their read loop detaches them shortly.
+func deliver(client *ClientConnection, event protocol.Envelope) {
+	if err := client.Send(event); errors.Is(err, errSlowConsumer) {
+		log.Printf("Dropped %s (%s) after its queue of %d events filled up", client.UserID, client.ID, sendQueueSize)
+	}
+}
+
+// ConnectionsHandler lists the live WebSocket connections of a user; users
+// can only list their own
+func (s *Server) ConnectionsHandler(manager *WSManager, w http.ResponseWriter, r *http.Request) {
+	userID := mux.Vars(r)["id"]
+	if userID != currentUser(r) {
+		log.Printf("ConnectionsHandler: %s may not list connections of %s", currentUser(r), userID)
+		http.Error(w, "you can only list your own connections", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(manager.Connections(userID)); err != nil {
+		log.Printf("ConnectionsHandler encode error: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// HandleWS serves a WebSocket connection. The client must offer a protocol
+// version among its subprotocols. A client that reconnects passes
+// resume_token and last_event in the query to resume its session; if that
+// fails it gets a new session and should sync to catch up.
 func (s *Server) HandleWS(manager *WSManager, w http.ResponseWriter, r *http.Request) {
-	userID := r.URL.Query().Get("user_id")
-	if userID == "" {
-		http.Error(w, "user_id required", http.StatusBadRequest)
+	userID := currentUser(r)
+
+	version, ok := protocol.Negotiate(websocket.Subprotocols(r))
+	if !ok {
+		log.Printf("User %s offered no supported protocol version: %v", userID, websocket.Subprotocols(r))
+		http.Error(w, fmt.Sprintf("unsupported protocol version, supported: %s",
+			strings.Join(protocol.Subprotocols(), ", ")), http.StatusBadRequest)
 		return
 	}
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	header := http.Header{}
+	header.Set("Sec-WebSocket-Protocol", protocol.Subprotocol(version))
+	conn, err := upgrader.Upgrade(w, r, header)
 	if err != nil {
 		log.Printf("WebSocket upgrade error: %v", err)
 		return
 	}
 
-	client := &ClientConnection{
-		UserID: userID,
-		Conn:   conn,
+	var client *ClientConnection
+	var sock *socket
+	if token := r.URL.Query().Get("resume_token"); token != "" {
+		lastEvent, _ := strconv.ParseInt(r.URL.Query().Get("last_event"), 10, 64)
+		client, sock, err = manager.Resume(userID, token, lastEvent, conn, r)
+		if err != nil {
+			log.Printf("User %s could not resume a session: %v", userID, err)
+		}
+	}
+	if sock == nil {
+		client = newClientConnection(userID)
+		sock = manager.RegisterClient(client, conn, r)
 	}
 
-	manager.RegisterClient(client)
-	log.Printf("User %s connected via WebSocket", userID)
+	// a client that neither sends anything nor answers pings for pongWait is
+	// gone; ReadJSON then fails and the connection is cleaned up
+	conn.SetReadLimit(maxFrameSize)
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
 
-	defer func() {
-		manager.UnregisterClient(client)
-		conn.Close()
-		log.Printf("User %s disconnected from WebSocket", userID)
+	// ctx lives as long as the connection; it is cancelled when the client
+	// goes away or the server shuts down, which also aborts any in-flight work
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		sock.close() // unblocks ReadJSON on shutdown
 	}()
 
-	for {
-		var msg struct {
-			Type   string `json:"type"`
-			ChatID string `json:"chat_id"`
-			Text   string `json:"text"`
+	log.Printf("User %s connected via WebSocket (%s, protocol v%d)", userID, client.ID, version)
+	if err := s.Service.UserConnected(ctx, userID); err != nil {
+		log.Printf("Failed to mark %s online: %v", userID, err)
+	}
+
+	defer func() {
+		manager.Detach(client, sock)
+		sock.close()
+		// ctx is done by now, but the last seen time still has to be stored
+		if err := s.Service.UserDisconnected(context.WithoutCancel(ctx), userID); err != nil {
+			log.Printf("Failed to mark %s offline: %v", userID, err)
 		}
+		log.Printf("User %s disconnected from WebSocket (%s)", userID, client.ID)
+	}()
 
-		if err := conn.ReadJSON(&msg); err != nil {
+	for {
+		var req protocol.Envelope
+		if err := conn.ReadJSON(&req); err != nil {
 			log.Printf("Error reading message from %s: %v", userID, err)
 			break
 		}
+		conn.SetReadDeadline(time.Now().Add(pongWait))
 
-		switch msg.Type {
-		case "subscribe":
-			manager.SubscribeClientToChat(userID, msg.ChatID)
-			log.Printf("User %s subscribed to chat %s", userID, msg.ChatID)
+		ack, reqErr := s.handleRequest(ctx, manager, client, req)
+		if reqErr != nil {
+			client.Send(protocol.Envelope{Type: protocol.TypeError, RequestID: req.RequestID, Error: reqErr})
+			continue
+		}
+		if req.RequestID != "" {
+			env := protocol.New(protocol.TypeAck, ack)
+			env.RequestID = req.RequestID
+			client.Send(env)
+		}
+	}
+}
 
-		case "unsubscribe":
-			manager.UnsubscribeClientFromChat(userID, msg.ChatID)
-			log.Printf("User %s unsubscribed from chat %s", userID, msg.ChatID)
+// handleRequest carries out a client request and returns the payload of its
+// ack, if any
+func (s *Server) handleRequest(ctx context.Context, manager *WSManager, client *ClientConnection, req protocol.Envelope) (any, *protocol.Error) {
+	userID := client.UserID
 
-		case "message":
-			if err := s.Service.SendMessage(userID, msg.ChatID, msg.Text); err != nil {
-				log.Printf("Failed to save message from %s: %v", userID, err)
-				continue
-			}
+	switch req.Type {
+	case protocol.TypeSubscribe:
+		var p protocol.ChatRef
+		if err := req.Decode(&p); err != nil {
+			return nil, badRequest(err)
+		}
+		if err := s.Service.CanSubscribe(ctx, userID, p.ChatID); err != nil {
+			log.Printf("User %s may not subscribe to chat %s: %v", userID, p.ChatID, err)
+			return nil, wsError(err, p.ChatID)
+		}
+		manager.SubscribeClientToChat(client, p.ChatID)
+		log.Printf("User %s subscribed to chat %s", userID, p.ChatID)
+
+	case protocol.TypeUnsubscribe:
+		var p protocol.ChatRef
+		if err := req.Decode(&p); err != nil {
+			return nil, badRequest(err)
+		}
+		manager.UnsubscribeClientFromChat(client, p.ChatID)
+		log.Printf("User %s unsubscribed from chat %s", userID, p.ChatID)
 
-			savedMsgs, err := s.Service.ListMessages(userID, msg.ChatID)
-			if err != nil || len(savedMsgs) == 0 {
-				log.Printf("Failed to retrieve saved message for broadcast: %v", err)
-				continue
+	case protocol.TypeSync:
+		var p protocol.Sync
+		if err := req.Decode(&p); err != nil {
+			return nil, badRequest(err)
+		}
+		// a chat that cannot be synced does not hold up the others; the
+		// ack tells which failed
+		var result protocol.SyncResult
+		for chatID, seq := range p.Positions {
+			if err := s.syncChat(ctx, manager, client, chatID, seq); err != nil {
+				log.Printf("Failed to sync chat %s for %s: %v", chatID, userID, err)
+				result.Failed = append(result.Failed, *wsError(err, chatID))
 			}
-			lastMsg := savedMsgs[len(savedMsgs)-1]
+		}
+		return result, nil
+
+	case protocol.TypeSend:
+		var p protocol.Send
+		if err := req.Decode(&p); err != nil {
+			return nil, badRequest(err)
+		}
+		// the service publishes the stored message, which reaches the
+		// chat's subscribers through HandleEvent; the ack tells the sender
+		// even if it is not subscribed
+		var stored domain.Message
+		var err error
+		if p.ReplyTo != "" {
+			stored, err = s.Service.ReplyToMessage(ctx, userID, p.ChatID, p.ReplyTo, p.Text, p.ClientID)
+		} else {
+			stored, err = s.Service.SendMessage(ctx, userID, p.ChatID, p.Text, p.ClientID)
+		}
+		if err != nil {
+			log.Printf("Failed to save message from %s: %v", userID, err)
+			return nil, wsError(err, p.ChatID)
+		}
+		log.Printf("Stored message %s from %s to chat %s", stored.ID, userID, stored.ChatID)
+		return protocol.MessageEvent{Message: stored}, nil
+
+	case protocol.TypeTypingStart:
+		var p protocol.ChatRef
+		if err := req.Decode(&p); err != nil {
+			return nil, badRequest(err)
+		}
+		if err := s.Service.StartTyping(ctx, userID, p.ChatID); err != nil {
+			log.Printf("Failed to start typing for %s in chat %s: %v", userID, p.ChatID, err)
+			return nil, wsError(err, p.ChatID)
+		}
 
-			// Broadcast the saved message to all subscribed clients
-			manager.BroadcastMessage(lastMsg)
-			log.Printf("Broadcasted message from %s to chat %s", userID, msg.ChatID)
+	case protocol.TypeTypingStop:
+		var p protocol.ChatRef
+		if err := req.Decode(&p); err != nil {
+			return nil, badRequest(err)
+		}
+		s.Service.StopTyping(userID, p.ChatID)
+
+	case protocol.TypeRead:
+		var p protocol.Read
+		if err := req.Decode(&p); err != nil {
+			return nil, badRequest(err)
+		}
+		if _, err := s.Service.MarkRead(ctx, userID, p.ChatID, p.MessageID); err != nil {
+			log.Printf("Failed to mark chat %s read for %s: %v", p.ChatID, userID, err)
+			return nil, wsError(err, p.ChatID)
+		}
+
+	default:
+		log.Printf("Unknown message type from %s: %s", userID, req.Type)
+		return nil, &protocol.Error{Code: protocol.CodeUnknownType, Message: fmt.Sprintf("unknown frame type %q", req.Type)}
+	}
+	return nil, nil
+}
+
+func badRequest(err error) *protocol.Error {
+	return &protocol.Error{Code: protocol.CodeBadRequest, Message: err.Error()}
+}
 
-		default:
-			log.Printf("Unknown message type from %s: %s", userID, msg.Type)
+// wsError classifies err for an error frame like httpStatus does for a
+// response
+func wsError(err error, chatID string) *protocol.Error {
+	code := protocol.CodeBadRequest
+	switch httpStatus(err) {
+	case http.StatusUnauthorized:
+		code = protocol.CodeUnauthenticated
+	case http.StatusForbidden:
+		code = protocol.CodeForbidden
+	case http.StatusNotFound:
+		code = protocol.CodeNotFound
+	case http.StatusConflict:
+		code = protocol.CodeConflict
+	case http.StatusGone:
+		code = protocol.CodeGone
+	case http.StatusInternalServerError, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		code = protocol.CodeInternal
+	}
+	return &protocol.Error{Code: code, Message: err.Error(), ChatID: chatID}
+}
+
+// syncChat subscribes client to a chat and sends it the messages stored after
+// seq in "missed" frames. It replays once before subscribing and once after,
+// so nothing stored in between is lost; a message may then arrive both live
+// and replayed, and clients skip the Seq they have seen.
+func (s *Server) syncChat(ctx context.Context, manager *WSManager, client *ClientConnection, chatID string, seq int64) error {
+	if err := s.Service.CanSubscribe(ctx, client.UserID, chatID); err != nil {
+		return err
+	}
+	seq, err := s.replayMissed(ctx, client, chatID, seq)
+	if err != nil {
+		return err
+	}
+	manager.SubscribeClientToChat(client, chatID)
+	_, err = s.replayMissed(ctx, client, chatID, seq)
+	return err
+}
+
+// replayMissed sends the messages of a chat after seq, a page per frame, and
+// returns the last Seq sent. A seq of 0 means the client has seen nothing of
+// the chat yet; rather than all of its history it gets the latest page.
+func (s *Server) replayMissed(ctx context.Context, client *ClientConnection, chatID string, seq int64) (int64, error) {
+	for {
+		page := domain.MessagePage{Limit: domain.MaxMessagePageSize}
+		if seq > 0 {
+			after := seq
+			page.AfterSeq = &after
+		}
+		msgs, err := s.Service.ListMessages(ctx, client.UserID, chatID, page)
+		if err != nil {
+			return seq, err
+		}
+		if len(msgs) == 0 {
+			return seq, nil
+		}
+		client.Send(protocol.New(protocol.TypeMissed, protocol.Missed{ChatID: chatID, Messages: msgs}))
+		seq = msgs[len(msgs)-1].Seq
+		if len(msgs) < page.Limit {
+			return seq, nil
 		}
 	}
 }
@@ -0,0 +1,359 @@
+package api
+
+import (
+	"cligram/internal/app"
+	"cligram/internal/domain"
+	"cligram/internal/memory"
+	"cligram/internal/protocol"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newTestServer returns a Server on the memory backend with the users alice,
+// bob and dave and the group chat "g" of alice and bob, and a WSManager for it
+func newTestServer(t *testing.T) (*Server, *WSManager) {
+	t.Helper()
+	ctx := context.Background()
+
+	store := memory.NewStore()
+	users := memory.NewUserRepo(store)
+	service := app.NewChatService(users, memory.NewChatRepo(store), memory.NewMessageRepo(store),
+		memory.NewReadReceiptRepo(store), app.NewEventBus())
+
+	for _, id := range []string{"alice", "bob", "dave"} {
+		if err := users.Create(ctx, domain.User{ID: id, Name: id}); err != nil {
+			t.Fatalf("create user %s: %v", id, err)
+		}
+	}
+	if _, err := service.CreateChat(ctx, "alice", domain.Chat{ID: "g", Name: "g", Members: []string{"bob"}}); err != nil {
+		t.Fatalf("create chat: %v", err)
+	}
+
+	server := &Server{Service: service, Auth: app.NewAuthService(users, memory.NewTokenRepo(store))}
+	return server, NewWSManager(service.Members)
+}
+
+// dial opens a WebSocket connection and returns both of its ends
+func dial(t *testing.T) (server, client *websocket.Conn) {
+	t.Helper()
+
+	conns := make(chan *websocket.Conn, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := http.Header{}
+		header.Set("Sec-WebSocket-Protocol", protocol.Subprotocol(protocol.Version))
+		conn, err := upgrader.Upgrade(w, r, header)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		conns <- conn
+	}))
+	t.Cleanup(ts.Close)
+
+	dialer := websocket.Dialer{Subprotocols: protocol.Subprotocols()}
+	client, _, err := dialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	server = <-conns
+	t.Cleanup(func() { server.Close() })
+	return server, client
+}
+
+// readFrames reads frames from conn until none arrives for a moment
+func readFrames(t *testing.T, conn *websocket.Conn) []protocol.Envelope {
+	t.Helper()
+
+	var frames []protocol.Envelope
+	for {
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		var env protocol.Envelope
+		if err := conn.ReadJSON(&env); err != nil {
+			var netErr interface{ Timeout() bool }
+			if !errors.As(err, &netErr) || !netErr.Timeout() {
+				t.Fatalf("read: %v", err)
+			}
+			return frames
+		}
+		frames = append(frames, env)
+	}
+}
+
+// received returns the types of the events sent to client, the connected
+// frame aside
+func received(client *ClientConnection) []protocol.Type {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	var types []protocol.Type
+	for _, event := range client.history {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+func TestBroadcastMembers(t *testing.T) {
+	// the store has bob as a member of "g" throughout; only the events tell
+	// the manager otherwise
+	var mu sync.Mutex
+	lookups := 0
+	manager := NewWSManager(func(ctx context.Context, chatID string) ([]string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		lookups++
+		return []string{"alice", "bob"}, nil
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	clients := map[string]*ClientConnection{}
+	for _, userID := range []string{"alice", "bob"} {
+		conn, _ := dial(t)
+		clients[userID] = newClientConnection(userID)
+		manager.RegisterClient(clients[userID], conn, req)
+		manager.SubscribeClientToChat(clients[userID], "g")
+	}
+	typing := protocol.New(protocol.TypeTypingStart, protocol.Typing{ChatID: "g", UserID: "alice"})
+	chat := func(version int64, members ...string) domain.Chat {
+		return domain.Chat{ID: "g", Members: members, Owner: "alice", Version: version}
+	}
+
+	steps := []struct {
+		name string
+		do   func()
+		// wantBob lists the events bob has received by then
+		wantBob []protocol.Type
+	}{
+		{
+			name:    "first event looks the members up",
+			do:      func() { manager.Broadcast("g", typing) },
+			wantBob: []protocol.Type{protocol.TypeTypingStart},
+		},
+		{
+			name:    "second event takes them from the cache",
+			do:      func() { manager.Broadcast("g", typing) },
+			wantBob: []protocol.Type{protocol.TypeTypingStart, protocol.TypeTypingStart},
+		},
+		{
+			name: "removed member hears of it",
+			do: func() {
+				manager.HandleEvent(app.MemberRemoved{Chat: chat(2, "alice"), UserID: "bob", By: "alice"})
+			},
+			wantBob: []protocol.Type{protocol.TypeTypingStart, protocol.TypeTypingStart, protocol.TypeMemberRemoved},
+		},
+		{
+			name: "removed member hears nothing more, even subscribed again",
+			do: func() {
+				manager.SubscribeClientToChat(clients["bob"], "g")
+				manager.Broadcast("g", typing)
+			},
+			wantBob: []protocol.Type{protocol.TypeTypingStart, protocol.TypeTypingStart, protocol.TypeMemberRemoved},
+		},
+		{
+			name: "added member hears from the chat again",
+			do: func() {
+				manager.HandleEvent(app.MemberAdded{Chat: chat(3, "alice", "bob"), UserID: "bob", By: "alice"})
+				manager.SubscribeClientToChat(clients["bob"], "g")
+				manager.Broadcast("g", typing)
+			},
+			wantBob: []protocol.Type{
+				protocol.TypeTypingStart, protocol.TypeTypingStart, protocol.TypeMemberRemoved,
+				protocol.TypeTypingStart,
+			},
+		},
+		{
+			name: "late event of an older version is ignored",
+			do: func() {
+				manager.HandleEvent(app.MemberRoleChanged{Chat: chat(2, "alice"), UserID: "alice", Role: domain.RoleOwner})
+				manager.Broadcast("g", typing)
+			},
+			wantBob: []protocol.Type{
+				protocol.TypeTypingStart, protocol.TypeTypingStart, protocol.TypeMemberRemoved,
+				protocol.TypeTypingStart,
+				protocol.TypeMemberRoleChanged, protocol.TypeTypingStart,
+			},
+		},
+	}
+	for _, step := range steps {
+		step.do()
+		if got := received(clients["bob"]); !slices.Equal(got, step.wantBob) {
+			t.Fatalf("%s: bob got %v, want %v", step.name, got, step.wantBob)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if lookups != 1 {
+		t.Errorf("looked the members up %d times, want once", lookups)
+	}
+}
+
+func TestResume(t *testing.T) {
+	tests := []struct {
+		name string
+		// sent is how many events the session got while detached
+		sent      int
+		userID    string
+		token     string // the session's resume token if empty
+		lastEvent int64
+		wantErr   error
+		// wantFirst is the first event replayed; the rest follow up to sent
+		wantFirst int64
+	}{
+		{name: "nothing missed", sent: 5, userID: "alice", lastEvent: 5, wantFirst: 6},
+		{name: "some missed", sent: 5, userID: "alice", lastEvent: 2, wantFirst: 3},
+		{name: "all missed", sent: 5, userID: "alice", lastEvent: 0, wantFirst: 1},
+		{name: "ahead of the session", sent: 5, userID: "alice", lastEvent: 6, wantErr: errSessionTooOld},
+		{
+			name: "history trimmed", sent: resumeHistorySize + 10, userID: "alice", lastEvent: 5,
+			wantErr: errSessionTooOld,
+		},
+		{
+			name: "history just kept", sent: resumeHistorySize + 10, userID: "alice", lastEvent: 10,
+			wantFirst: 11,
+		},
+		{name: "other user", sent: 5, userID: "bob", lastEvent: 5, wantErr: errSessionExpired},
+		{name: "unknown token", sent: 5, userID: "alice", token: "nope", lastEvent: 5, wantErr: errSessionExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, manager := newTestServer(t)
+			req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+
+			conn, remote := dial(t)
+			client := newClientConnection("alice")
+			sock := manager.RegisterClient(client, conn, req)
+			readFrames(t, remote) // connected
+			manager.Detach(client, sock)
+
+			for i := range tt.sent {
+				client.Send(protocol.New(protocol.TypeTypingStart, protocol.Typing{ChatID: "g", UserID: fmt.Sprint(i)}))
+			}
+
+			token := tt.token
+			if token == "" {
+				token = client.resumeToken
+			}
+			conn, remote = dial(t)
+			resumed, _, err := manager.Resume(tt.userID, token, tt.lastEvent, conn, req)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if resumed != client {
+				t.Fatalf("resumed session %s, want %s", resumed.ID, client.ID)
+			}
+
+			frames := readFrames(t, remote)
+			var connected protocol.Connected
+			if len(frames) == 0 || frames[0].Decode(&connected) != nil || !connected.Resumed {
+				t.Fatalf("first frame is not a resumed connected frame: %+v", frames)
+			}
+			for i, frame := range frames[1:] {
+				if want := tt.wantFirst + int64(i); frame.Seq != want {
+					t.Fatalf("frame %d has Seq %d, want %d", i+1, frame.Seq, want)
+				}
+			}
+			if got, want := int64(len(frames)-1), int64(tt.sent)-tt.wantFirst+1; got != want {
+				t.Errorf("replayed %d events, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestSyncChat(t *testing.T) {
+	tests := []struct {
+		name   string
+		userID string
+		stored int
+		seq    int64
+		// wantFrames lists the first and last Seq of every "missed" frame
+		wantFrames [][2]int64
+		wantErr    error
+	}{
+		{name: "from the start", userID: "bob", stored: 3, wantFrames: [][2]int64{{1, 3}}},
+		{name: "from a position", userID: "bob", stored: 3, seq: 1, wantFrames: [][2]int64{{2, 3}}},
+		{name: "up to date", userID: "bob", stored: 3, seq: 3},
+		{name: "empty chat", userID: "bob"},
+		{
+			name: "from the start of a long chat", userID: "bob", stored: domain.MaxMessagePageSize + 50,
+			wantFrames: [][2]int64{{51, domain.MaxMessagePageSize + 50}},
+		},
+		{
+			name: "several pages", userID: "bob", stored: 2*domain.MaxMessagePageSize + 50, seq: 10,
+			wantFrames: [][2]int64{
+				{11, domain.MaxMessagePageSize + 10},
+				{domain.MaxMessagePageSize + 11, 2*domain.MaxMessagePageSize + 10},
+				{2*domain.MaxMessagePageSize + 11, 2*domain.MaxMessagePageSize + 50},
+			},
+		},
+		{name: "not a member", userID: "dave", stored: 3, wantErr: domain.ErrUserNotInChat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			server, manager := newTestServer(t)
+			for i := range tt.stored {
+				if _, err := server.Service.SendMessage(ctx, "alice", "g", fmt.Sprint(i), ""); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			conn, _ := dial(t)
+			client := newClientConnection(tt.userID)
+			manager.RegisterClient(client, conn, httptest.NewRequest(http.MethodGet, "/ws", nil))
+
+			err := server.syncChat(ctx, manager, client, "g", tt.seq)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			client.mu.Lock()
+			history := slices.Clone(client.history)
+			client.mu.Unlock()
+
+			var frames [][2]int64
+			for _, event := range history {
+				var missed protocol.Missed
+				if event.Type != protocol.TypeMissed || event.Decode(&missed) != nil {
+					t.Fatalf("unexpected frame %+v", event)
+				}
+				msgs := missed.Messages
+				for i := 1; i < len(msgs); i++ {
+					if msgs[i].Seq != msgs[i-1].Seq+1 {
+						t.Fatalf("frame skips from Seq %d to %d", msgs[i-1].Seq, msgs[i].Seq)
+					}
+				}
+				frames = append(frames, [2]int64{msgs[0].Seq, msgs[len(msgs)-1].Seq})
+			}
+			if !slices.Equal(frames, tt.wantFrames) {
+				t.Errorf("got frames %v, want %v", frames, tt.wantFrames)
+			}
+
+			manager.Mutex.RLock()
+			_, subscribed := manager.ChatClients["g"][client.ID]
+			manager.Mutex.RUnlock()
+			if !subscribed {
+				t.Error("client is not subscribed to the chat")
+			}
+		})
+	}
+}
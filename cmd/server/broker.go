@@ -0,0 +1,50 @@
+package main
+
+import (
+	"cligram/internal/app"
+	"cligram/internal/broker"
+	"cligram/internal/db"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// Brokers selectable with -broker or CLIGRAM_BROKER
+const (
+	BrokerLocal = "local"
+	BrokerMongo = "mongo"
+)
+
+// openBroker returns the broker live events go through, and the counter of
+// connections that presence is based on if it must be shared too; nil keeps
+// the service's own. The mongo broker watches the messages and events
+// collections until ctx is done, so it needs the mongo storage backend.
+func openBroker(ctx context.Context, kind, storage string) (app.Broker, app.ConnectionCounter, error) {
+	switch kind {
+	case BrokerLocal:
+		return app.NewEventBus(), nil, nil
+
+	case BrokerMongo:
+		if storage != StorageMongo {
+			return nil, nil, fmt.Errorf("the %s broker needs the %s storage backend", BrokerMongo, StorageMongo)
+		}
+		client := db.Connect()
+		changes := broker.NewChangeStream(client)
+		go func() {
+			if err := changes.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				log.Printf("Change streams stopped: %v", err)
+			}
+		}()
+		connections := broker.NewConnections(client)
+		go func() {
+			if err := connections.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				log.Printf("Connection counting stopped: %v", err)
+			}
+		}()
+		return changes, connections, nil
+
+	default:
+		return nil, nil, fmt.Errorf("unknown broker %q (expected %s or %s)", kind, BrokerLocal, BrokerMongo)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"cligram/internal/db"
+	"cligram/internal/domain"
+	"cligram/internal/filestore"
+	"cligram/internal/memory"
+	"cmp"
+	"context"
+	"flag"
+	"log"
+	"slices"
+	"time"
+)
+
+// importMongoCmd copies every user, chat, message and read receipt from
+// MongoDB into a data file for the file storage backend.
+func importMongoCmd(args []string) {
+	fs := flag.NewFlagSet("import-mongo", flag.ExitOnError)
+	path := fs.String("file", envOr("CLIGRAM_DATA_FILE", defaultDataFile), "data file to import into")
+	fs.Parse(args)
+
+	fileDB, err := filestore.Open(*path)
+	if err != nil {
+		log.Fatalf("Failed to open %s: %v", *path, err)
+	}
+	defer fileDB.Close()
+
+	if len(fileDB.Store().Records()) > 0 {
+		log.Fatalf("%s already contains data; import into a new file", *path)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	dump, err := db.Export(ctx, db.Connect())
+	if err != nil {
+		log.Fatalf("Failed to read MongoDB: %v", err)
+	}
+
+	// messages written before sequence numbers existed have seq 0; number
+	// them after the sequenced ones in the order they were written
+	slices.SortStableFunc(dump.Messages, func(a, b domain.Message) int {
+		if a.Seq == 0 || b.Seq == 0 {
+			return cmp.Or(cmp.Compare(b.Seq, a.Seq), a.CreatedAt.Compare(b.CreatedAt))
+		}
+		return cmp.Compare(a.Seq, b.Seq)
+	})
+	lastSeq := make(map[string]int64)
+	for i, m := range dump.Messages {
+		if m.Seq == 0 {
+			dump.Messages[i].Seq = lastSeq[m.ChatID] + 1
+		}
+		lastSeq[m.ChatID] = dump.Messages[i].Seq
+	}
+
+	recs := make([]memory.Record, 0, len(dump.Users)+len(dump.Chats)+len(dump.Messages)+len(dump.Receipts))
+	for _, u := range dump.Users {
+		recs = append(recs, memory.Record{User: &u})
+	}
+	for _, c := range dump.Chats {
+		recs = append(recs, memory.Record{Chat: &c})
+	}
+	for _, m := range dump.Messages {
+		recs = append(recs, memory.Record{Message: &m})
+	}
+	for _, r := range dump.Receipts {
+		recs = append(recs, memory.Record{Receipt: &r})
+	}
+
+	if err := fileDB.Import(recs); err != nil {
+		log.Fatalf("Failed to write %s: %v", *path, err)
+	}
+
+	log.Printf("Imported %d users, %d chats, %d messages and %d read receipts into %s",
+		len(dump.Users), len(dump.Chats), len(dump.Messages), len(dump.Receipts), *path)
+}
@@ -4,9 +4,17 @@ import (
 	"cligram/cmd/server/api"
 	"cligram/internal/app"
 	"cligram/internal/db"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -16,36 +24,119 @@ type Server struct {
 }
 
 func main() {
-	client := db.Connect()
+	subcommands := map[string]func([]string){
+		"import-mongo": importMongoCmd,
+		"migrate":      migrateCmd,
+		"set-password": setPasswordCmd,
+	}
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			cmd(os.Args[2:])
+			return
+		}
+	}
 
-	users := db.NewUserRepo(client)
-	chats := db.NewChatRepo(client)
-	messages := db.NewMessageRepo(client)
+	storage := flag.String("storage", envOr("CLIGRAM_STORAGE", StorageMongo), "storage backend: mongo, memory or file")
+	dataFile := flag.String("data-file", envOr("CLIGRAM_DATA_FILE", defaultDataFile), "data file for the file storage backend")
+	dbTimeout := flag.Duration("db-timeout", envDurationOr("CLIGRAM_DB_TIMEOUT", 5*time.Second), "maximum duration of a single MongoDB call (0 for none)")
+	requestTimeout := flag.Duration("request-timeout", envDurationOr("CLIGRAM_REQUEST_TIMEOUT", 15*time.Second), "maximum duration of an HTTP request (0 for none)")
+	autoMigrate := flag.Bool("auto-migrate", envOr("CLIGRAM_AUTO_MIGRATE", "true") == "true", "apply pending MongoDB migrations on startup")
+	brokerKind := flag.String("broker", envOr("CLIGRAM_BROKER", BrokerLocal), "live event broker: local, or mongo to share events and presence between server instances")
+	flag.Parse()
 
-	service := app.NewChatService(users, chats, messages)
-	server := &api.Server{Service: service}
+	// every request context derives from ctx, so stopping the server cancels
+	// whatever is still in flight, including open WebSocket connections
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	r := mux.NewRouter()
+	db.SetQueryTimeout(*dbTimeout)
+
+	repos, err := openStorage(*storage, *dataFile, *autoMigrate)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Using %s storage", *storage)
+
+	events, connections, err := openBroker(ctx, *brokerKind, *storage)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Using %s broker", *brokerKind)
+
+	service := app.NewChatService(repos.users, repos.chats, repos.messages, repos.receipts, events)
+	if connections != nil {
+		service.SetConnectionCounter(connections)
+	}
+	auth := app.NewAuthService(repos.users, repos.tokens)
+	server := &api.Server{Service: service, Auth: auth}
+
+	router := mux.NewRouter()
+
+	// Public endpoints
+	router.HandleFunc("/users", server.CreateUserHandler).Methods("POST")
+	router.HandleFunc("/login", server.LoginHandler).Methods("POST")
+
+	// everything else needs a bearer token
+	r := router.NewRoute().Subrouter()
+	r.Use(func(next http.Handler) http.Handler { return api.AuthMiddleware(auth, next) })
 
 	// User endpoints
-	r.HandleFunc("/users", server.CreateUserHandler).Methods("POST")
+	r.HandleFunc("/logout", server.LogoutHandler).Methods("POST")
+	r.HandleFunc("/users/{id}/presence", server.PresenceHandler).Methods("GET")
 
 	// Chat endpoints
 	r.HandleFunc("/chats", server.CreateChatHandler).Methods("POST")
 	r.HandleFunc("/chats", server.ListChatsHandler).Methods("GET")
+	r.HandleFunc("/chats/direct", server.DirectChatHandler).Methods("POST")
 	r.HandleFunc("/chats/{id}", server.GetChatHandler).Methods("GET")
+	r.HandleFunc("/chats/{id}/members", server.AddMemberHandler).Methods("POST")
+	r.HandleFunc("/chats/{id}/members/{member}", server.RemoveMemberHandler).Methods("DELETE")
+	r.HandleFunc("/chats/{id}/members/{member}/role", server.SetMemberRoleHandler).Methods("PUT")
+	r.HandleFunc("/chats/{id}/bans/{member}", server.UnbanMemberHandler).Methods("DELETE")
+	r.HandleFunc("/chats/{id}/read", server.MarkReadHandler).Methods("POST")
+	r.HandleFunc("/chats/{id}/read", server.ReadReceiptsHandler).Methods("GET")
 
 	// Message endpoints
 	r.HandleFunc("/messages", server.SendMessageHandler).Methods("POST")
 	r.HandleFunc("/messages", server.ListMessagesHandler).Methods("GET")
+	r.HandleFunc("/messages/{id}", server.EditMessageHandler).Methods("PATCH")
+	r.HandleFunc("/messages/{id}", server.DeleteMessageHandler).Methods("DELETE")
+	r.HandleFunc("/messages/{id}/history", server.MessageHistoryHandler).Methods("GET")
+	r.HandleFunc("/messages/{id}/thread", server.ThreadHandler).Methods("GET")
+	r.HandleFunc("/messages/{id}/reactions", server.AddReactionHandler).Methods("POST")
+	r.HandleFunc("/messages/{id}/reactions", server.ListReactionsHandler).Methods("GET")
+	r.HandleFunc("/messages/{id}/reactions/{emoji}", server.RemoveReactionHandler).Methods("DELETE")
+	r.HandleFunc("/search", server.SearchMessagesHandler).Methods("GET")
 
-	wsManager := api.NewWSManager()
+	wsManager := api.NewWSManager(service.Members)
+	events.Subscribe(wsManager.HandleEvent)
 	r.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
 		server.HandleWS(wsManager, w, r)
 	})
+	r.HandleFunc("/users/{id}/connections", func(w http.ResponseWriter, r *http.Request) {
+		server.ConnectionsHandler(wsManager, w, r)
+	}).Methods("GET")
+
+	httpHandler := api.LoggingMiddleware(api.TimeoutMiddleware(*requestTimeout, router))
+
+	httpServer := &http.Server{
+		Addr:        ":8080",
+		Handler:     httpHandler,
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
 
-	httpHandler := api.LoggingMiddleware(r)
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Shutdown error: %v", err)
+		}
+	}()
 
 	fmt.Println("Server running on :8080")
-	log.Fatal(http.ListenAndServe(":8080", httpHandler))
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+	log.Println("Server stopped")
 }
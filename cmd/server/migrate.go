@@ -0,0 +1,124 @@
+package main
+
+import (
+	"cligram/internal/db"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// migrateCmd applies, reverts or lists the MongoDB schema migrations:
+//
+//	cligram-server migrate up [-to N]
+//	cligram-server migrate down [-to N]
+//	cligram-server migrate status
+func migrateCmd(args []string) {
+	if len(args) < 1 {
+		fmt.Println("Usage: cligram-server migrate <up|down|status> [-to N]")
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet("migrate "+args[0], flag.ExitOnError)
+	to := fs.Int("to", -1, "target version (up: latest, down: one step back)")
+	fs.Parse(args[1:])
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	migrator := db.NewMigrator(db.Connect())
+
+	switch args[0] {
+	case "up":
+		target := *to
+		if target < 0 {
+			target = db.LatestVersion
+		}
+		done, err := migrator.Up(ctx, target)
+		for _, m := range done {
+			log.Printf("Applied %d: %s", m.Version, m.Description)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(done) == 0 {
+			log.Println("Nothing to apply")
+		}
+
+	case "down":
+		target := *to
+		if target < 0 {
+			target = currentVersion(ctx, migrator) - 1
+		}
+		done, err := migrator.Down(ctx, max(target, 0))
+		for _, m := range done {
+			log.Printf("Reverted %d: %s", m.Version, m.Description)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(done) == 0 {
+			log.Println("Nothing to revert")
+		}
+
+	case "status":
+		states, err := migrator.Status(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, s := range states {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = "applied " + s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%4d  %-22s %s\n", s.Version, applied, s.Description)
+		}
+
+	default:
+		fmt.Println("Unknown migrate command:", args[0])
+		os.Exit(2)
+	}
+}
+
+func currentVersion(ctx context.Context, migrator *db.Migrator) int {
+	states, err := migrator.Status(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	version := 0
+	for _, s := range states {
+		if s.AppliedAt != nil {
+			version = s.Version
+		}
+	}
+	return version
+}
+
+// migrateOnStartup brings the database up to date before the server uses it,
+// or refuses to start on an outdated schema when auto-migration is off
+func migrateOnStartup(client *mongo.Client, auto bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	migrator := db.NewMigrator(client)
+	if !auto {
+		pending, err := migrator.Pending(ctx)
+		if err != nil {
+			return err
+		}
+		if len(pending) > 0 {
+			return fmt.Errorf("%d pending migration(s); run `cligram-server migrate up`", len(pending))
+		}
+		return nil
+	}
+
+	done, err := migrator.Up(ctx, db.LatestVersion)
+	for _, m := range done {
+		log.Printf("Applied migration %d: %s", m.Version, m.Description)
+	}
+	return err
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bufio"
+	"cligram/internal/app"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// setPasswordCmd sets the password of an existing user, read from stdin. Users
+// created before passwords existed need one before they can log in:
+//
+//	cligram-server set-password [-storage mongo] <user_id>
+func setPasswordCmd(args []string) {
+	fs := flag.NewFlagSet("set-password", flag.ExitOnError)
+	storage := fs.String("storage", envOr("CLIGRAM_STORAGE", StorageMongo), "storage backend: mongo or file")
+	dataFile := fs.String("data-file", envOr("CLIGRAM_DATA_FILE", defaultDataFile), "data file for the file storage backend")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Println("Usage: cligram-server set-password [-storage mongo|file] [-data-file path] <user_id>")
+		os.Exit(2)
+	}
+	userID := fs.Arg(0)
+
+	repos, err := openStorage(*storage, *dataFile, false)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Fprintf(os.Stderr, "New password for %s: ", userID)
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		log.Fatalf("Failed to read password: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	auth := app.NewAuthService(repos.users, repos.tokens)
+	if err := auth.SetPassword(ctx, userID, strings.TrimRight(password, "\r\n")); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Password of %s updated", userID)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"cligram/internal/db"
+	"cligram/internal/domain/repository"
+	"cligram/internal/filestore"
+	"cligram/internal/memory"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// Storage backends selectable with -storage or CLIGRAM_STORAGE
+const (
+	StorageMongo  = "mongo"
+	StorageMemory = "memory"
+	StorageFile   = "file"
+)
+
+const defaultDataFile = "cligram.db"
+
+type repositories struct {
+	users    repository.UserRepository
+	chats    repository.ChatRepository
+	messages repository.MessageRepository
+	receipts repository.ReadReceiptRepository
+	tokens   repository.TokenRepository
+}
+
+func openStorage(kind, dataFile string, autoMigrate bool) (repositories, error) {
+	switch kind {
+	case StorageMongo:
+		client := db.Connect()
+		if err := migrateOnStartup(client, autoMigrate); err != nil {
+			return repositories{}, err
+		}
+		return repositories{
+			users:    db.NewUserRepo(client),
+			chats:    db.NewChatRepo(client),
+			messages: db.NewMessageRepo(client),
+			receipts: db.NewReadReceiptRepo(client),
+			tokens:   db.NewTokenRepo(client),
+		}, nil
+
+	case StorageMemory:
+		store := memory.NewStore()
+		return repositories{
+			users:    memory.NewUserRepo(store),
+			chats:    memory.NewChatRepo(store),
+			messages: memory.NewMessageRepo(store),
+			receipts: memory.NewReadReceiptRepo(store),
+			tokens:   memory.NewTokenRepo(store),
+		}, nil
+
+	case StorageFile:
+		fileDB, err := filestore.Open(dataFile)
+		if err != nil {
+			return repositories{}, fmt.Errorf("open data file %s: %w", dataFile, err)
+		}
+		store := fileDB.Store()
+		return repositories{
+			users:    memory.NewUserRepo(store),
+			chats:    memory.NewChatRepo(store),
+			messages: memory.NewMessageRepo(store),
+			receipts: memory.NewReadReceiptRepo(store),
+			tokens:   memory.NewTokenRepo(store),
+		}, nil
+
+	default:
+		return repositories{}, fmt.Errorf("unknown storage backend %q (expected %s, %s or %s)", kind, StorageMongo, StorageMemory, StorageFile)
+	}
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func envDurationOr(key string, fallback time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("invalid %s %q: %v", key, v, err)
+	}
+	return d
+}
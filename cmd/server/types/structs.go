@@ -1,28 +1,75 @@
 package types
 
-import "cligram/internal/app"
+import (
+	"cligram/internal/app"
+	"time"
+)
 
 type Server struct {
 	service *app.ChatService
 }
 
 type CreateUserRequest struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+type LoginRequest struct {
+	UserID   string `json:"user_id"`
+	Password string `json:"password"`
+}
+
+type LoginResponse struct {
+	UserID    string    `json:"user_id"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
 
 type CreateChatRequest struct {
-	ID      string   `json:"id"`
-	Members []string `json:"members"`
+	ID          string   `json:"id"`
+	Name        string   `json:"name,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Members     []string `json:"members"`
+}
+
+type DirectChatRequest struct {
+	// OtherID is the user to chat with
+	OtherID string `json:"other_id"`
 }
 
 type SendMessageRequest struct {
-	From   string `json:"from"`
 	ChatID string `json:"chat_id"`
 	Text   string `json:"text"`
+	// ReplyTo makes the message a reply; the chat is then taken from that message
+	ReplyTo string `json:"reply_to,omitempty"`
+	// ClientID is an optional idempotency key: retrying with the same one
+	// does not store the message twice
+	ClientID string `json:"client_id,omitempty"`
 }
 
 type ListMessagesRequest struct {
-	UserID string `json:"user_id"`
 	ChatID string `json:"chat_id"`
 }
+
+type EditMessageRequest struct {
+	Text string `json:"text"`
+}
+
+type ReactionRequest struct {
+	Emoji string `json:"emoji"`
+}
+
+type AddMemberRequest struct {
+	MemberID string `json:"member_id"`
+}
+
+type MemberRoleRequest struct {
+	// Role is either "admin" or "member"
+	Role string `json:"role"`
+}
+
+type MarkReadRequest struct {
+	// MessageID is the last message read; empty means the latest in the chat
+	MessageID string `json:"message_id,omitempty"`
+}
@@ -0,0 +1,149 @@
+package app
+
+import (
+	"cligram/internal/domain"
+	"cligram/internal/domain/repository"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TokenLifetime is how long a token issued by Login stays valid
+const TokenLifetime = 30 * 24 * time.Hour
+
+// MinPasswordLength is the shortest password Register and SetPassword accept
+const MinPasswordLength = 8
+
+// MaxPasswordLength is the longest password Register and SetPassword accept,
+// in bytes. bcrypt ignores everything after it.
+const MaxPasswordLength = 72
+
+// dummyHash is compared against when a user has no password, so that a login
+// takes as long whether or not the user exists
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+	return hash
+})
+
+// AuthService registers users and issues and checks the bearer tokens they
+// authenticate with. Tokens are random strings handed to the client once;
+// only their SHA-256 hash is stored.
+type AuthService struct {
+	users  repository.UserRepository
+	tokens repository.TokenRepository
+}
+
+func NewAuthService(users repository.UserRepository, tokens repository.TokenRepository) *AuthService {
+	return &AuthService{users: users, tokens: tokens}
+}
+
+// Register creates a user who can log in with password
+func (s *AuthService) Register(ctx context.Context, id, name, password string) error {
+	if id == "" {
+		return fmt.Errorf("%w: user id cannot be empty", domain.ErrInvalidInput)
+	}
+	if name == "" {
+		return fmt.Errorf("%w: user name cannot be empty", domain.ErrInvalidInput)
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	return s.users.Create(ctx, domain.User{ID: id, Name: name, PasswordHash: hash})
+}
+
+// SetPassword replaces the password of an existing user, which is also how
+// users created before passwords existed get one
+func (s *AuthService) SetPassword(ctx context.Context, id, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	return s.users.SetPasswordHash(ctx, id, hash)
+}
+
+// Login checks the password of userID and issues a new token for them
+func (s *AuthService) Login(ctx context.Context, userID, password string) (token string, expiresAt time.Time, err error) {
+	user, err := s.users.GetByID(ctx, userID)
+	if err != nil && !errors.Is(err, domain.ErrUserNotFound) {
+		return "", time.Time{}, err
+	}
+	if len(user.PasswordHash) == 0 {
+		bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		return "", time.Time{}, domain.ErrInvalidCredentials
+	}
+	if bcrypt.CompareHashAndPassword(user.PasswordHash, []byte(password)) != nil {
+		return "", time.Time{}, domain.ErrInvalidCredentials
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", time.Time{}, err
+	}
+	token = base64.RawURLEncoding.EncodeToString(raw)
+
+	now := time.Now()
+	expiresAt = now.Add(TokenLifetime)
+	err = s.tokens.Create(ctx, domain.AuthToken{
+		Hash:      hashToken(token),
+		UserID:    user.ID,
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// Logout revokes token. Unknown tokens are ignored.
+func (s *AuthService) Logout(ctx context.Context, token string) error {
+	err := s.tokens.Revoke(ctx, hashToken(token), time.Now())
+	if errors.Is(err, domain.ErrTokenNotFound) {
+		return nil
+	}
+	return err
+}
+
+// Authenticate returns the user token was issued to, or ErrUnauthenticated
+// if it is unknown, revoked or expired
+func (s *AuthService) Authenticate(ctx context.Context, token string) (string, error) {
+	if token == "" {
+		return "", domain.ErrUnauthenticated
+	}
+	t, err := s.tokens.GetByHash(ctx, hashToken(token))
+	if errors.Is(err, domain.ErrTokenNotFound) {
+		return "", domain.ErrUnauthenticated
+	}
+	if err != nil {
+		return "", err
+	}
+	if t.Expired(time.Now()) {
+		return "", domain.ErrUnauthenticated
+	}
+	return t.UserID, nil
+}
+
+func hashPassword(password string) ([]byte, error) {
+	if len(password) < MinPasswordLength {
+		return nil, fmt.Errorf("%w: password must be at least %d characters long", domain.ErrInvalidInput, MinPasswordLength)
+	}
+	if len(password) > MaxPasswordLength {
+		return nil, fmt.Errorf("%w: password must be at most %d bytes long", domain.ErrInvalidInput, MaxPasswordLength)
+	}
+	return bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
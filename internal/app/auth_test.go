@@ -0,0 +1,124 @@
+package app_test
+
+import (
+	"cligram/internal/app"
+	"cligram/internal/domain"
+	"cligram/internal/memory"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func newAuthService(t *testing.T) *app.AuthService {
+	t.Helper()
+	ctx := context.Background()
+
+	store := memory.NewStore()
+	users := memory.NewUserRepo(store)
+	auth := app.NewAuthService(users, memory.NewTokenRepo(store))
+	if err := auth.Register(ctx, "alice", "Alice", "alicepass123"); err != nil {
+		t.Fatalf("register alice: %v", err)
+	}
+	// created before passwords existed
+	if err := users.Create(ctx, domain.User{ID: "bob", Name: "Bob"}); err != nil {
+		t.Fatalf("create bob: %v", err)
+	}
+	return auth
+}
+
+func TestRegister(t *testing.T) {
+	auth := newAuthService(t)
+
+	tests := []struct {
+		name     string
+		id       string
+		userName string
+		password string
+		wantErr  error
+	}{
+		{name: "new user", id: "carol", userName: "Carol", password: "carolpass123"},
+		{name: "empty id", userName: "Carol", password: "carolpass123", wantErr: domain.ErrInvalidInput},
+		{name: "empty name", id: "carol", password: "carolpass123", wantErr: domain.ErrInvalidInput},
+		{name: "short password", id: "carol", userName: "Carol", password: "short", wantErr: domain.ErrInvalidInput},
+		{
+			name: "longest password", id: "dave", userName: "Dave",
+			password: strings.Repeat("p", app.MaxPasswordLength),
+		},
+		{
+			name: "too long password", id: "erin", userName: "Erin",
+			password: strings.Repeat("p", app.MaxPasswordLength+1), wantErr: domain.ErrInvalidInput,
+		},
+		{name: "taken id", id: "alice", userName: "Alice", password: "alicepass123", wantErr: domain.ErrUserExists},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := auth.Register(context.Background(), tt.id, tt.userName, tt.password)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLogin(t *testing.T) {
+	auth := newAuthService(t)
+
+	tests := []struct {
+		name     string
+		userID   string
+		password string
+		wantErr  error
+	}{
+		{name: "right password", userID: "alice", password: "alicepass123"},
+		{name: "wrong password", userID: "alice", password: "bobpass123", wantErr: domain.ErrInvalidCredentials},
+		{name: "unknown user", userID: "erin", password: "erinpass123", wantErr: domain.ErrInvalidCredentials},
+		{name: "user without password", userID: "bob", password: "", wantErr: domain.ErrInvalidCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			token, _, err := auth.Login(ctx, tt.userID, tt.password)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			userID, err := auth.Authenticate(ctx, token)
+			if err != nil || userID != tt.userID {
+				t.Errorf("token authenticates as %q (%v), want %q", userID, err, tt.userID)
+			}
+		})
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	ctx := context.Background()
+	auth := newAuthService(t)
+
+	loggedOut, _, err := auth.Login(ctx, "alice", "alicepass123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.Logout(ctx, loggedOut); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{name: "no token"},
+		{name: "unknown token", token: "not-a-token"},
+		{name: "logged out", token: loggedOut},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := auth.Authenticate(ctx, tt.token); !errors.Is(err, domain.ErrUnauthenticated) {
+				t.Errorf("got error %v, want %v", err, domain.ErrUnauthenticated)
+			}
+		})
+	}
+}
@@ -3,8 +3,10 @@ package app
 import (
 	"cligram/internal/domain"
 	"cligram/internal/domain/repository"
+	"context"
 	"errors"
 	"fmt"
+	"log"
 	"slices"
 	"time"
 
@@ -15,30 +17,46 @@ type ChatService struct {
 	users    repository.UserRepository
 	chats    repository.ChatRepository
 	messages repository.MessageRepository
+	receipts repository.ReadReceiptRepository
+	events   Publisher
+	typing   *typingTracker
+	presence ConnectionCounter
 }
 
 func NewChatService(
 	users repository.UserRepository,
 	chats repository.ChatRepository,
 	messages repository.MessageRepository,
+	receipts repository.ReadReceiptRepository,
+	events Publisher,
 ) *ChatService {
-	return &ChatService{users, chats, messages}
+	return &ChatService{
+		users:    users,
+		chats:    chats,
+		messages: messages,
+		receipts: receipts,
+		events:   events,
+		typing:   newTypingTracker(),
+		presence: newPresenceTracker(),
+	}
 }
 
 func (s *ChatService) SendMessage(
+	ctx context.Context,
 	fromUserID string,
 	chatID string,
 	text string,
-) error {
+	clientID string,
+) (domain.Message, error) {
 	// 1. ensure user exists
-	if _, err := s.users.GetByID(fromUserID); err != nil {
-		return domain.ErrUserNotFound
+	if _, err := s.users.GetByID(ctx, fromUserID); err != nil {
+		return domain.Message{}, err
 	}
 
 	// 2. ensure chat exists
-	chat, err := s.chats.GetByID(chatID)
+	chat, err := s.chats.GetByID(ctx, chatID)
 	if err != nil {
-		return domain.ErrChatNotFound
+		return domain.Message{}, err
 	}
 
 	// 3. ensure user is a member of the chat
@@ -51,75 +69,202 @@ func (s *ChatService) SendMessage(
 	}
 
 	if !isMember {
-		return domain.ErrUserNotInChat
+		return domain.Message{}, domain.ErrUserNotInChat
 	}
 
-	// 4. persist message
-	msg := domain.Message{
+	// 4. persist message and let live subscribers know
+	return s.storeMessage(ctx, newMessage(fromUserID, chatID, text, clientID))
+}
+
+// MaxClientIDLength bounds the idempotency keys clients send with messages
+const MaxClientIDLength = 64
+
+func newMessage(fromUserID, chatID, text, clientID string) domain.Message {
+	return domain.Message{
 		ID:        fmt.Sprintf("%s-%d", uuid.NewString(), time.Now().UnixNano()),
 		From:      fromUserID,
 		ChatID:    chatID,
 		Text:      text,
 		CreatedAt: time.Now(),
+		ClientID:  clientID,
 	}
-
-	return s.messages.Create(msg)
 }
 
-func (s *ChatService) CreateUser(id, name string) error {
-	if name == "" {
-		return errors.New("user name cannot be empty")
+// storeMessage persists a new message and publishes it, so it reaches live
+// subscribers whichever entry point sent it, and returns it as stored. A
+// retry of a message stored already returns that message without storing or
+// publishing it again.
+func (s *ChatService) storeMessage(ctx context.Context, msg domain.Message) (domain.Message, error) {
+	if msg.Text == "" {
+		return domain.Message{}, fmt.Errorf("%w: message text cannot be empty", domain.ErrInvalidInput)
+	}
+	if len(msg.ClientID) > MaxClientIDLength {
+		return domain.Message{}, fmt.Errorf("%w: client id cannot be longer than %d bytes", domain.ErrInvalidInput, MaxClientIDLength)
 	}
 
-	user := domain.User{
-		ID:   id,
-		Name: name,
+	stored, err := s.messages.Create(ctx, msg)
+	if errors.Is(err, domain.ErrDuplicateMessage) {
+		if !isRetryOf(msg, stored) {
+			return domain.Message{}, fmt.Errorf("%w: %s", domain.ErrClientIDReused, msg.ClientID)
+		}
+		return stored, nil
+	}
+	if err != nil {
+		return domain.Message{}, err
 	}
 
-	return s.users.Create(user)
+	// the message is what the sender was typing
+	s.StopTyping(stored.From, stored.ChatID)
+	s.events.Publish(MessageCreated{Message: stored})
+
+	if stored.ThreadRoot != "" {
+		// the reply is stored, so a failure here must not make the sender
+		// retry it; the count is only off by one
+		root, err := s.messages.CountReply(ctx, stored.ThreadRoot)
+		if err != nil {
+			log.Printf("Failed to count reply %s in thread %s: %v", stored.ID, stored.ThreadRoot, err)
+		} else {
+			s.events.Publish(ThreadUpdated{Root: root})
+		}
+	}
+	return stored, nil
 }
 
-func (s *ChatService) GetChatByID(chatID string) (domain.Chat, error) {
-	chat, err := s.chats.GetByID(chatID)
+// isRetryOf tells whether msg, sent with the client ID of stored, is the same
+// message sent again. stored may have been edited or deleted since.
+func isRetryOf(msg, stored domain.Message) bool {
+	if msg.ChatID != stored.ChatID || msg.ReplyTo != stored.ReplyTo {
+		return false
+	}
+	text := stored.Text
+	if len(stored.Revisions) > 0 {
+		text = stored.Revisions[0].Text
+	}
+	return stored.Deleted() || msg.Text == text
+}
+
+// GetChat returns the chat if userID is one of its members
+func (s *ChatService) GetChat(ctx context.Context, userID, chatID string) (domain.Chat, error) {
+	return s.requireMember(ctx, userID, chatID)
+}
+
+// Members returns the members of a chat, who are the only users its events
+// may be delivered to
+func (s *ChatService) Members(ctx context.Context, chatID string) ([]string, error) {
+	chat, err := s.chats.GetByID(ctx, chatID)
 	if err != nil {
-		return domain.Chat{}, domain.ErrChatNotFound
+		return nil, err
 	}
-	return chat, nil
+	return chat.Members, nil
+}
+
+// CanSubscribe tells whether userID may receive the live events of the chat,
+// which only its members may
+func (s *ChatService) CanSubscribe(ctx context.Context, userID, chatID string) error {
+	_, err := s.requireMember(ctx, userID, chatID)
+	return err
 }
 
-func (s *ChatService) CreateChat(id string, memberIDs []string) error {
-	if len(memberIDs) < 2 {
-		return errors.New("chat must have at least two members")
+// CreateChat creates a group chat from the ID, name, description and members
+// of chat. The creator owns it and is added to the members if missing.
+func (s *ChatService) CreateChat(ctx context.Context, creatorID string, chat domain.Chat) (domain.Chat, error) {
+	if chat.ID == "" {
+		return domain.Chat{}, fmt.Errorf("%w: chat id cannot be empty", domain.ErrInvalidInput)
+	}
+	if !slices.Contains(chat.Members, creatorID) {
+		chat.Members = append([]string{creatorID}, chat.Members...)
+	}
+	if len(chat.Members) < 2 {
+		return domain.Chat{}, fmt.Errorf("%w: chat must have at least two members", domain.ErrInvalidInput)
 	}
 
 	seen := make(map[string]struct{})
-	for _, userID := range memberIDs {
+	for _, userID := range chat.Members {
 		if _, ok := seen[userID]; ok {
-			return errors.New("duplicate user in chat members")
+			return domain.Chat{}, fmt.Errorf("%w: duplicate user in chat members", domain.ErrInvalidInput)
 		}
 		seen[userID] = struct{}{}
 
-		if _, err := s.users.GetByID(userID); err != nil {
-			return domain.ErrUserNotFound
+		if _, err := s.users.GetByID(ctx, userID); err != nil {
+			return domain.Chat{}, err
 		}
 	}
 
-	chat := domain.Chat{
-		ID:      id,
-		Members: memberIDs,
+	chat = domain.Chat{
+		ID:          chat.ID,
+		Type:        domain.ChatTypeGroup,
+		Name:        chat.Name,
+		Description: chat.Description,
+		CreatedAt:   time.Now(),
+		CreatedBy:   creatorID,
+		Members:     chat.Members,
+		Owner:       creatorID,
+	}
+	if err := s.chats.Create(ctx, chat); err != nil {
+		return domain.Chat{}, err
+	}
+	return chat, nil
+}
+
+// DirectChat returns the direct chat between userID and otherID, creating it
+// on first use. created tells whether it is new.
+func (s *ChatService) DirectChat(ctx context.Context, userID, otherID string) (chat domain.Chat, created bool, err error) {
+	if userID == otherID {
+		return domain.Chat{}, false, fmt.Errorf("%w: cannot start a direct chat with yourself", domain.ErrInvalidInput)
+	}
+	for _, id := range []string{userID, otherID} {
+		if _, err := s.users.GetByID(ctx, id); err != nil {
+			return domain.Chat{}, false, err
+		}
+	}
+
+	key := domain.DirectChatKey(userID, otherID)
+	chat, err = s.chats.GetDirect(ctx, key)
+	if !errors.Is(err, domain.ErrChatNotFound) {
+		return chat, false, err
 	}
 
-	return s.chats.Create(chat)
+	chat = domain.Chat{
+		ID:        uuid.NewString(),
+		Type:      domain.ChatTypeDirect,
+		CreatedAt: time.Now(),
+		CreatedBy: userID,
+		Members:   []string{userID, otherID},
+		DirectKey: key,
+	}
+	err = s.chats.Create(ctx, chat)
+	if errors.Is(err, domain.ErrChatExists) {
+		// the other user started it at the same time
+		chat, err = s.chats.GetDirect(ctx, key)
+		return chat, false, err
+	}
+	if err != nil {
+		return domain.Chat{}, false, err
+	}
+	return chat, true, nil
 }
 
 func (s *ChatService) ListMessages(
+	ctx context.Context,
 	requestingUserID string,
 	chatID string,
+	page domain.MessagePage,
 ) ([]domain.Message, error) {
+	if page.Before != "" && page.After != "" ||
+		page.AfterSeq != nil && (page.Before != "" || page.After != "") {
+		return nil, fmt.Errorf("%w: only one of before, after and after_seq can be set", domain.ErrInvalidInput)
+	}
+	if page.AfterSeq != nil && *page.AfterSeq < 0 {
+		return nil, fmt.Errorf("%w: after_seq cannot be negative", domain.ErrInvalidInput)
+	}
+	if page.Limit < 0 {
+		return nil, fmt.Errorf("%w: limit cannot be negative", domain.ErrInvalidInput)
+	}
+	page.Limit = page.Size()
 
-	chat, err := s.chats.GetByID(chatID)
+	chat, err := s.chats.GetByID(ctx, chatID)
 	if err != nil {
-		return nil, domain.ErrChatNotFound
+		return nil, err
 	}
 
 	isMember := slices.Contains(chat.Members, requestingUserID)
@@ -128,15 +273,27 @@ func (s *ChatService) ListMessages(
 		return nil, domain.ErrUserNotInChat
 	}
 
-	return s.messages.ListByChat(chatID)
+	return s.messages.ListByChat(ctx, chatID, page)
 }
 
-func (s *ChatService) ListUserChats(userID string) ([]domain.Chat, error) {
+func (s *ChatService) ListUserChats(ctx context.Context, userID string) ([]domain.Chat, error) {
 	// check if user exists
-	_, err := s.users.GetByID(userID)
+	_, err := s.users.GetByID(ctx, userID)
 	if err != nil {
-		return nil, domain.ErrUserNotFound
+		return nil, err
 	}
 
-	return s.chats.ListByUser(userID)
+	return s.chats.ListByUser(ctx, userID)
+}
+
+// requireMember returns the chat if userID is one of its members
+func (s *ChatService) requireMember(ctx context.Context, userID, chatID string) (domain.Chat, error) {
+	chat, err := s.chats.GetByID(ctx, chatID)
+	if err != nil {
+		return domain.Chat{}, err
+	}
+	if !slices.Contains(chat.Members, userID) {
+		return domain.Chat{}, domain.ErrUserNotInChat
+	}
+	return chat, nil
 }
@@ -0,0 +1,247 @@
+package app_test
+
+import (
+	"cligram/internal/app"
+	"cligram/internal/domain"
+	"cligram/internal/memory"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recorder is a Publisher that keeps what it is given
+type recorder struct {
+	mu     sync.Mutex
+	events []app.Event
+}
+
+func (r *recorder) Publish(e app.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+}
+
+func (r *recorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.events)
+}
+
+// since returns the events published after the first n
+func (r *recorder) since(n int) []app.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.events[n:])
+}
+
+// testEnv is a ChatService on the memory backend with the users alice, bob,
+// carol and dave and the group chat "g" owned by alice, with bob and carol
+// as members
+type testEnv struct {
+	users   *memory.UserRepo
+	service *app.ChatService
+	events  *recorder
+}
+
+func newTestEnv(t *testing.T) testEnv {
+	t.Helper()
+	ctx := context.Background()
+
+	store := memory.NewStore()
+	users := memory.NewUserRepo(store)
+	events := &recorder{}
+	service := app.NewChatService(users, memory.NewChatRepo(store), memory.NewMessageRepo(store),
+		memory.NewReadReceiptRepo(store), events)
+
+	for _, id := range []string{"alice", "bob", "carol", "dave"} {
+		if err := users.Create(ctx, domain.User{ID: id, Name: id}); err != nil {
+			t.Fatalf("create user %s: %v", id, err)
+		}
+	}
+	if _, err := service.CreateChat(ctx, "alice", domain.Chat{ID: "g", Name: "g", Members: []string{"bob", "carol"}}); err != nil {
+		t.Fatalf("create chat: %v", err)
+	}
+	return testEnv{users: users, service: service, events: events}
+}
+
+// sendAll sends n messages to "g" as alice, with the texts "1" to "n"
+func sendAll(t *testing.T, env testEnv, n int) []domain.Message {
+	t.Helper()
+	msgs := make([]domain.Message, n)
+	for i := range msgs {
+		msg, err := env.service.SendMessage(context.Background(), "alice", "g", fmt.Sprint(i+1), "")
+		if err != nil {
+			t.Fatalf("send %d: %v", i+1, err)
+		}
+		msgs[i] = msg
+	}
+	return msgs
+}
+
+// seqRange returns the first and last Seq of msgs, or zeros if there are none
+func seqRange(msgs []domain.Message) [2]int64 {
+	if len(msgs) == 0 {
+		return [2]int64{}
+	}
+	return [2]int64{msgs[0].Seq, msgs[len(msgs)-1].Seq}
+}
+
+func seqOf(n int64) *int64 { return &n }
+
+func TestListMessages(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t)
+	sent := sendAll(t, env, domain.MaxMessagePageSize+10)
+	last := int64(len(sent))
+
+	tests := []struct {
+		name    string
+		userID  string
+		page    domain.MessagePage
+		want    [2]int64
+		wantErr error
+	}{
+		{name: "latest page", page: domain.MessagePage{}, want: [2]int64{last - domain.DefaultMessagePageSize + 1, last}},
+		{name: "latest few", page: domain.MessagePage{Limit: 3}, want: [2]int64{last - 2, last}},
+		{name: "before", page: domain.MessagePage{Before: sent[20].ID, Limit: 5}, want: [2]int64{16, 20}},
+		{name: "before the first", page: domain.MessagePage{Before: sent[0].ID}},
+		{name: "after", page: domain.MessagePage{After: sent[20].ID, Limit: 5}, want: [2]int64{22, 26}},
+		{name: "after the last", page: domain.MessagePage{After: sent[last-1].ID}},
+		{name: "after_seq", page: domain.MessagePage{AfterSeq: seqOf(20), Limit: 5}, want: [2]int64{21, 25}},
+		{name: "after_seq 0", page: domain.MessagePage{AfterSeq: seqOf(0), Limit: 5}, want: [2]int64{1, 5}},
+		{name: "after_seq at the end", page: domain.MessagePage{AfterSeq: seqOf(last)}},
+		{name: "negative after_seq", page: domain.MessagePage{AfterSeq: seqOf(-1)}, wantErr: domain.ErrInvalidInput},
+		{
+			name: "after_seq and before", page: domain.MessagePage{AfterSeq: seqOf(1), Before: sent[5].ID},
+			wantErr: domain.ErrInvalidInput,
+		},
+		{name: "limit capped", page: domain.MessagePage{Limit: domain.MaxMessagePageSize + 1}, want: [2]int64{11, last}},
+		{name: "unknown cursor", page: domain.MessagePage{Before: "nope"}, wantErr: domain.ErrMessageNotFound},
+		{
+			name: "before and after", page: domain.MessagePage{Before: sent[1].ID, After: sent[0].ID},
+			wantErr: domain.ErrInvalidInput,
+		},
+		{name: "negative limit", page: domain.MessagePage{Limit: -1}, wantErr: domain.ErrInvalidInput},
+		{name: "not a member", userID: "dave", wantErr: domain.ErrUserNotInChat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID := tt.userID
+			if userID == "" {
+				userID = "bob"
+			}
+			msgs, err := env.service.ListMessages(ctx, userID, "g", tt.page)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if got := seqRange(msgs); got != tt.want {
+				t.Errorf("got Seq range %v, want %v", got, tt.want)
+			}
+			if tt.want != [2]int64{} && int64(len(msgs)) != tt.want[1]-tt.want[0]+1 {
+				t.Errorf("got %d messages for Seq range %v", len(msgs), tt.want)
+			}
+		})
+	}
+}
+
+func TestSeqIsPerChat(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t)
+	sendAll(t, env, 3)
+
+	dm, _, err := env.service.DirectChat(ctx, "alice", "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for want := int64(1); want <= 2; want++ {
+		msg, err := env.service.SendMessage(ctx, "bob", dm.ID, "hi", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Seq != want {
+			t.Errorf("got Seq %d in a new chat, want %d", msg.Seq, want)
+		}
+	}
+}
+
+func TestSendMessageDedupe(t *testing.T) {
+	tests := []struct {
+		name string
+		// before runs on the message first sent as alice with client ID
+		// "c1" and the text "hi"
+		before   func(ctx context.Context, env testEnv, first domain.Message) error
+		from     string
+		text     string
+		clientID string
+		wantErr  error
+		wantSame bool
+	}{
+		{name: "retry", from: "alice", text: "hi", clientID: "c1", wantSame: true},
+		{name: "different text", from: "alice", text: "ho", clientID: "c1", wantErr: domain.ErrClientIDReused},
+		{name: "other sender", from: "bob", text: "hi", clientID: "c1"},
+		{name: "other client id", from: "alice", text: "hi", clientID: "c2"},
+		{name: "no client id", from: "alice", text: "hi"},
+		{
+			name: "retry after edit",
+			before: func(ctx context.Context, env testEnv, first domain.Message) error {
+				_, err := env.service.EditMessage(ctx, "alice", first.ID, "hello")
+				return err
+			},
+			from: "alice", text: "hi", clientID: "c1", wantSame: true,
+		},
+		{
+			name: "retry after delete",
+			before: func(ctx context.Context, env testEnv, first domain.Message) error {
+				_, err := env.service.DeleteMessage(ctx, "alice", first.ID)
+				return err
+			},
+			from: "alice", text: "hi", clientID: "c1", wantSame: true,
+		},
+		{
+			name: "client id too long",
+			from: "alice", text: "hi", clientID: strings.Repeat("x", app.MaxClientIDLength+1),
+			wantErr: domain.ErrInvalidInput,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			env := newTestEnv(t)
+
+			first, err := env.service.SendMessage(ctx, "alice", "g", "hi", "c1")
+			if err != nil {
+				t.Fatalf("first send: %v", err)
+			}
+			if tt.before != nil {
+				if err := tt.before(ctx, env, first); err != nil {
+					t.Fatalf("before: %v", err)
+				}
+			}
+			published := env.events.count()
+
+			msg, err := env.service.SendMessage(ctx, tt.from, "g", tt.text, tt.clientID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if same := msg.ID == first.ID; same != tt.wantSame {
+				t.Errorf("got message %s, first was %s; want same: %v", msg.ID, first.ID, tt.wantSame)
+			}
+			if tt.wantSame && env.events.count() != published {
+				t.Errorf("a retry published %d events", env.events.count()-published)
+			}
+			if !tt.wantSame && msg.Seq != first.Seq+1 {
+				t.Errorf("got Seq %d, want %d", msg.Seq, first.Seq+1)
+			}
+		})
+	}
+}
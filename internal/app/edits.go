@@ -0,0 +1,104 @@
+package app
+
+import (
+	"cligram/internal/domain"
+	"context"
+	"fmt"
+	"time"
+)
+
+// MessageEdited is published after a message's text has changed
+type MessageEdited struct {
+	Message domain.Message
+}
+
+func (e MessageEdited) ChatID() string { return e.Message.ChatID }
+
+// MessageDeleted is published after a message has become a tombstone
+type MessageDeleted struct {
+	Message domain.Message
+}
+
+func (e MessageDeleted) ChatID() string { return e.Message.ChatID }
+
+// EditMessage replaces the text of one of userID's own messages
+func (s *ChatService) EditMessage(
+	ctx context.Context,
+	userID string,
+	messageID string,
+	text string,
+) (domain.Message, error) {
+	if text == "" {
+		return domain.Message{}, fmt.Errorf("%w: message text cannot be empty", domain.ErrInvalidInput)
+	}
+	if _, err := s.ownMessage(ctx, userID, messageID); err != nil {
+		return domain.Message{}, err
+	}
+
+	msg, err := s.messages.Edit(ctx, messageID, text, time.Now())
+	if err != nil {
+		return domain.Message{}, err
+	}
+
+	s.events.Publish(MessageEdited{Message: msg})
+	return msg, nil
+}
+
+// DeleteMessage turns one of userID's own messages into a tombstone
+func (s *ChatService) DeleteMessage(
+	ctx context.Context,
+	userID string,
+	messageID string,
+) (domain.Message, error) {
+	if _, err := s.ownMessage(ctx, userID, messageID); err != nil {
+		return domain.Message{}, err
+	}
+
+	msg, err := s.messages.Delete(ctx, messageID, time.Now())
+	if err != nil {
+		return domain.Message{}, err
+	}
+
+	s.events.Publish(MessageDeleted{Message: msg})
+	return msg, nil
+}
+
+// MessageHistory returns every version of a message's text, oldest first and
+// ending with the current one. Any member of the chat may read it.
+func (s *ChatService) MessageHistory(
+	ctx context.Context,
+	userID string,
+	messageID string,
+) ([]domain.MessageRevision, error) {
+	msg, err := s.messages.GetByID(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.requireMember(ctx, userID, msg.ChatID); err != nil {
+		return nil, err
+	}
+	if msg.Deleted() {
+		return nil, domain.ErrMessageDeleted
+	}
+
+	current := domain.MessageRevision{Text: msg.Text, CreatedAt: msg.CreatedAt}
+	if msg.EditedAt != nil {
+		current.CreatedAt = *msg.EditedAt
+	}
+	return append(msg.Revisions, current), nil
+}
+
+// ownMessage returns the message if userID wrote it and is still in its chat
+func (s *ChatService) ownMessage(ctx context.Context, userID, messageID string) (domain.Message, error) {
+	msg, err := s.messages.GetByID(ctx, messageID)
+	if err != nil {
+		return domain.Message{}, err
+	}
+	if msg.From != userID {
+		return domain.Message{}, domain.ErrNotMessageOwner
+	}
+	if _, err := s.requireMember(ctx, userID, msg.ChatID); err != nil {
+		return domain.Message{}, err
+	}
+	return msg, nil
+}
@@ -0,0 +1,125 @@
+package app_test
+
+import (
+	"cligram/internal/domain"
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestEmptyText(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t)
+	msg, err := env.service.SendMessage(ctx, "alice", "g", "hi", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		send func() error
+	}{
+		{name: "send", send: func() error {
+			_, err := env.service.SendMessage(ctx, "alice", "g", "", "")
+			return err
+		}},
+		{name: "reply", send: func() error {
+			_, err := env.service.ReplyToMessage(ctx, "bob", "g", msg.ID, "", "")
+			return err
+		}},
+		{name: "edit", send: func() error {
+			_, err := env.service.EditMessage(ctx, "alice", msg.ID, "")
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.send(); !errors.Is(err, domain.ErrInvalidInput) {
+				t.Errorf("got error %v, want %v", err, domain.ErrInvalidInput)
+			}
+		})
+	}
+}
+
+func TestEditAndDelete(t *testing.T) {
+	tests := []struct {
+		name string
+		// change runs on a message alice sent with the text "hi"
+		change   func(ctx context.Context, env testEnv, id string) (domain.Message, error)
+		wantErr  error
+		wantText []string // the history of the message afterwards
+	}{
+		{
+			name: "edit",
+			change: func(ctx context.Context, env testEnv, id string) (domain.Message, error) {
+				return env.service.EditMessage(ctx, "alice", id, "hello")
+			},
+			wantText: []string{"hi", "hello"},
+		},
+		{
+			name: "edit twice",
+			change: func(ctx context.Context, env testEnv, id string) (domain.Message, error) {
+				if _, err := env.service.EditMessage(ctx, "alice", id, "hello"); err != nil {
+					return domain.Message{}, err
+				}
+				return env.service.EditMessage(ctx, "alice", id, "hey")
+			},
+			wantText: []string{"hi", "hello", "hey"},
+		},
+		{
+			name: "edit someone else's",
+			change: func(ctx context.Context, env testEnv, id string) (domain.Message, error) {
+				return env.service.EditMessage(ctx, "bob", id, "hello")
+			},
+			wantErr: domain.ErrNotMessageOwner,
+		},
+		{
+			name: "delete someone else's",
+			change: func(ctx context.Context, env testEnv, id string) (domain.Message, error) {
+				return env.service.DeleteMessage(ctx, "bob", id)
+			},
+			wantErr: domain.ErrNotMessageOwner,
+		},
+		{
+			name: "edit deleted",
+			change: func(ctx context.Context, env testEnv, id string) (domain.Message, error) {
+				if _, err := env.service.DeleteMessage(ctx, "alice", id); err != nil {
+					return domain.Message{}, err
+				}
+				return env.service.EditMessage(ctx, "alice", id, "hello")
+			},
+			wantErr: domain.ErrMessageDeleted,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			env := newTestEnv(t)
+			msg, err := env.service.SendMessage(ctx, "alice", "g", "hi", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := tt.change(ctx, env, msg.ID); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			history, err := env.service.MessageHistory(ctx, "carol", msg.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var texts []string
+			for _, r := range history {
+				texts = append(texts, r.Text)
+			}
+			if !slices.Equal(texts, tt.wantText) {
+				t.Errorf("got history %q, want %q", texts, tt.wantText)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package app
+
+import (
+	"cligram/internal/domain"
+	"sync"
+)
+
+// Event is something that happened in ChatService that other parts of the
+// system, such as the WebSocket layer, react to
+type Event interface {
+	// ChatID is the chat the event belongs to, or "" if it is not about a
+	// single chat; subscribers use it for routing
+	ChatID() string
+}
+
+// MessageCreated is published once a message has been stored
+type MessageCreated struct {
+	Message domain.Message
+}
+
+func (e MessageCreated) ChatID() string { return e.Message.ChatID }
+
+// Publisher receives the events emitted by ChatService
+type Publisher interface {
+	Publish(e Event)
+}
+
+type EventHandler func(e Event)
+
+// Broker carries the events ChatService publishes to the subscribers that
+// react to them, such as the WebSocket layer. Implementations may deliver
+// events published by other server instances too.
+type Broker interface {
+	Publisher
+	// Subscribe registers h for all future events and returns a function
+	// that removes it again
+	Subscribe(h EventHandler) (unsubscribe func())
+}
+
+// EventBus is an in-process Broker. Every published event is handed to
+// each subscriber exactly once, synchronously and in publish order, so a
+// handler that does slow work should hand it off to its own goroutine.
+type EventBus struct {
+	mu       sync.RWMutex
+	nextID   int
+	handlers map[int]EventHandler
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{handlers: make(map[int]EventHandler)}
+}
+
+// Subscribe registers h for all future events and returns a function that
+// removes it again
+func (b *EventBus) Subscribe(h EventHandler) (unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.nextID
+	b.nextID++
+	b.handlers[id] = h
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.handlers, id)
+	}
+}
+
+// Publish implements Publisher
+func (b *EventBus) Publish(e Event) {
+	// handlers run without the lock held so they may (un)subscribe
+	b.mu.RLock()
+	handlers := make([]EventHandler, 0, len(b.handlers))
+	for _, h := range b.handlers {
+		handlers = append(handlers, h)
+	}
+	b.mu.RUnlock()
+
+	for _, h := range handlers {
+		h(e)
+	}
+}
@@ -0,0 +1,9 @@
+package app
+
+import "time"
+
+// SetTypingTimeout shortens how long typing indicators last, so tests can
+// wait for them to expire
+func SetTypingTimeout(s *ChatService, d time.Duration) {
+	s.typing.timeout = d
+}
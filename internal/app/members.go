@@ -0,0 +1,265 @@
+package app
+
+import (
+	"cligram/internal/domain"
+	"context"
+	"errors"
+	"slices"
+)
+
+// MemberAdded is published after a user has joined a chat
+type MemberAdded struct {
+	Chat   domain.Chat
+	UserID string
+	By     string
+}
+
+func (e MemberAdded) ChatID() string { return e.Chat.ID }
+
+// MemberRemoved is published after a user has left a chat or been removed
+// from it. Banned is set when they were kicked.
+type MemberRemoved struct {
+	Chat   domain.Chat
+	UserID string
+	By     string
+	Banned bool
+}
+
+func (e MemberRemoved) ChatID() string { return e.Chat.ID }
+
+// MemberRoleChanged is published after a member was promoted or demoted
+type MemberRoleChanged struct {
+	Chat   domain.Chat
+	UserID string
+	Role   domain.ChatRole
+}
+
+func (e MemberRoleChanged) ChatID() string { return e.Chat.ID }
+
+// AddMember adds userID to the chat. actorID must be an admin or the owner,
+// and userID must not be banned.
+func (s *ChatService) AddMember(ctx context.Context, actorID, chatID, userID string) (domain.Chat, error) {
+	chat, err := s.updateChat(ctx, chatID, func(chat *domain.Chat) error {
+		if err := checkRole(*chat, actorID, domain.RoleAdmin); err != nil {
+			return err
+		}
+		if _, err := s.users.GetByID(ctx, userID); err != nil {
+			return err
+		}
+		if slices.Contains(chat.Members, userID) {
+			return domain.ErrAlreadyMember
+		}
+		if slices.Contains(chat.Banned, userID) {
+			return domain.ErrUserBanned
+		}
+		chat.Members = append(chat.Members, userID)
+		return nil
+	})
+	if err != nil {
+		return domain.Chat{}, err
+	}
+
+	s.events.Publish(MemberAdded{Chat: chat, UserID: userID, By: actorID})
+	return chat, nil
+}
+
+// RemoveMember takes userID out of the chat. Admins can remove members, the
+// owner can remove anyone but themselves. With ban set userID is also kicked:
+// they cannot be added back until unbanned.
+func (s *ChatService) RemoveMember(
+	ctx context.Context,
+	actorID string,
+	chatID string,
+	userID string,
+	ban bool,
+) (domain.Chat, error) {
+	chat, err := s.updateChat(ctx, chatID, func(chat *domain.Chat) error {
+		if err := checkRole(*chat, actorID, domain.RoleAdmin); err != nil {
+			return err
+		}
+		if !chat.RoleOf(actorID).CanManage(chat.RoleOf(userID)) {
+			return domain.ErrNotAllowed
+		}
+		if !slices.Contains(chat.Members, userID) && !ban {
+			return domain.ErrUserNotInChat
+		}
+		*chat = withoutMember(*chat, userID)
+		if ban && !slices.Contains(chat.Banned, userID) {
+			chat.Banned = append(chat.Banned, userID)
+		}
+		return nil
+	})
+	if err != nil {
+		return domain.Chat{}, err
+	}
+
+	s.events.Publish(MemberRemoved{Chat: chat, UserID: userID, By: actorID, Banned: ban})
+	return chat, nil
+}
+
+// UnbanMember lifts a ban so userID can be added to the chat again
+func (s *ChatService) UnbanMember(ctx context.Context, actorID, chatID, userID string) (domain.Chat, error) {
+	return s.updateChat(ctx, chatID, func(chat *domain.Chat) error {
+		if err := checkRole(*chat, actorID, domain.RoleAdmin); err != nil {
+			return err
+		}
+		if !slices.Contains(chat.Banned, userID) {
+			return errUnchanged
+		}
+		chat.Banned = slices.DeleteFunc(chat.Banned, func(id string) bool { return id == userID })
+		return nil
+	})
+}
+
+// SetMemberRole promotes userID to admin or demotes them back to member. Only
+// the owner can change roles, and ownership itself only moves on LeaveChat.
+func (s *ChatService) SetMemberRole(
+	ctx context.Context,
+	actorID string,
+	chatID string,
+	userID string,
+	role domain.ChatRole,
+) (domain.Chat, error) {
+	var changed bool
+	chat, err := s.updateChat(ctx, chatID, func(chat *domain.Chat) error {
+		changed = false
+		if err := checkRole(*chat, actorID, domain.RoleOwner); err != nil {
+			return err
+		}
+		current := chat.RoleOf(userID)
+		switch {
+		case current == "":
+			return domain.ErrUserNotInChat
+		case current == domain.RoleOwner, role != domain.RoleAdmin && role != domain.RoleMember:
+			return domain.ErrNotAllowed
+		case current == role:
+			return errUnchanged
+		}
+
+		if role == domain.RoleAdmin {
+			chat.Admins = append(chat.Admins, userID)
+		} else {
+			chat.Admins = slices.DeleteFunc(chat.Admins, func(id string) bool { return id == userID })
+		}
+		changed = true
+		return nil
+	})
+	if err != nil {
+		return domain.Chat{}, err
+	}
+
+	if changed {
+		s.events.Publish(MemberRoleChanged{Chat: chat, UserID: userID, Role: role})
+	}
+	return chat, nil
+}
+
+// LeaveChat removes userID from the chat. If the owner leaves, ownership
+// passes to the longest-standing admin, or failing that to the
+// longest-standing member.
+func (s *ChatService) LeaveChat(ctx context.Context, userID, chatID string) error {
+	var wasOwner bool
+	chat, err := s.updateChat(ctx, chatID, func(chat *domain.Chat) error {
+		if err := checkRole(*chat, userID, domain.RoleMember); err != nil {
+			return err
+		}
+
+		wasOwner = chat.Owner == userID
+		*chat = withoutMember(*chat, userID)
+		if wasOwner {
+			chat.Owner = ""
+			switch {
+			case len(chat.Admins) > 0:
+				chat.Owner = chat.Admins[0]
+				chat.Admins = chat.Admins[1:]
+			case len(chat.Members) > 0:
+				chat.Owner = chat.Members[0]
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.events.Publish(MemberRemoved{Chat: chat, UserID: userID, By: userID})
+	if wasOwner && chat.Owner != "" {
+		s.events.Publish(MemberRoleChanged{Chat: chat, UserID: chat.Owner, Role: domain.RoleOwner})
+	}
+	return nil
+}
+
+// maxUpdateAttempts bounds how often updateChat starts over after losing a
+// race with another update
+const maxUpdateAttempts = 5
+
+// errUnchanged is returned by an updateChat change that finds nothing to do
+var errUnchanged = errors.New("chat unchanged")
+
+// updateChat reads a chat, lets change check and modify it, and stores the
+// result. If another update was stored in between, it starts over with the
+// newer chat, so change always decides on the current members and roles.
+// A change returning errUnchanged leaves the chat as it is.
+func (s *ChatService) updateChat(
+	ctx context.Context,
+	chatID string,
+	change func(chat *domain.Chat) error,
+) (domain.Chat, error) {
+	for range maxUpdateAttempts {
+		chat, err := s.chats.GetByID(ctx, chatID)
+		if err != nil {
+			return domain.Chat{}, err
+		}
+		err = change(&chat)
+		if errors.Is(err, errUnchanged) {
+			return chat, nil
+		}
+		if err != nil {
+			return domain.Chat{}, err
+		}
+
+		err = s.chats.Update(ctx, chat)
+		if errors.Is(err, domain.ErrChatChanged) {
+			continue
+		}
+		if err != nil {
+			return domain.Chat{}, err
+		}
+		chat.Version++
+		return chat, nil
+	}
+	return domain.Chat{}, domain.ErrChatChanged
+}
+
+// checkRole tells whether userID is a member of the group chat with at least
+// role
+func checkRole(chat domain.Chat, userID string, role domain.ChatRole) error {
+	if !slices.Contains(chat.Members, userID) {
+		return domain.ErrUserNotInChat
+	}
+	if chat.IsDirect() {
+		return domain.ErrDirectChat
+	}
+
+	switch chat.RoleOf(userID) {
+	case domain.RoleOwner:
+		return nil
+	case domain.RoleAdmin:
+		if role != domain.RoleOwner {
+			return nil
+		}
+	case domain.RoleMember:
+		if role == domain.RoleMember {
+			return nil
+		}
+	}
+	return domain.ErrNotAllowed
+}
+
+// withoutMember drops userID from the members and admins of chat
+func withoutMember(chat domain.Chat, userID string) domain.Chat {
+	isUser := func(id string) bool { return id == userID }
+	chat.Members = slices.DeleteFunc(chat.Members, isUser)
+	chat.Admins = slices.DeleteFunc(chat.Admins, isUser)
+	return chat
+}
@@ -0,0 +1,279 @@
+package app_test
+
+import (
+	"cligram/internal/domain"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestMembers(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup runs as alice, the owner of "g", before the change
+		setup   func(ctx context.Context, env testEnv) error
+		change  func(ctx context.Context, env testEnv) (domain.Chat, error)
+		wantErr error
+		check   func(t *testing.T, chat domain.Chat)
+	}{
+		{
+			name: "owner adds a user",
+			change: func(ctx context.Context, env testEnv) (domain.Chat, error) {
+				return env.service.AddMember(ctx, "alice", "g", "dave")
+			},
+			check: func(t *testing.T, chat domain.Chat) {
+				if !slices.Contains(chat.Members, "dave") {
+					t.Errorf("dave is not among %v", chat.Members)
+				}
+			},
+		},
+		{
+			name: "admin adds a user",
+			setup: func(ctx context.Context, env testEnv) error {
+				_, err := env.service.SetMemberRole(ctx, "alice", "g", "bob", domain.RoleAdmin)
+				return err
+			},
+			change: func(ctx context.Context, env testEnv) (domain.Chat, error) {
+				return env.service.AddMember(ctx, "bob", "g", "dave")
+			},
+		},
+		{
+			name: "member adds a user",
+			change: func(ctx context.Context, env testEnv) (domain.Chat, error) {
+				return env.service.AddMember(ctx, "bob", "g", "dave")
+			},
+			wantErr: domain.ErrNotAllowed,
+		},
+		{
+			name: "outsider adds a user",
+			change: func(ctx context.Context, env testEnv) (domain.Chat, error) {
+				return env.service.AddMember(ctx, "dave", "g", "dave")
+			},
+			wantErr: domain.ErrUserNotInChat,
+		},
+		{
+			name: "add a member",
+			change: func(ctx context.Context, env testEnv) (domain.Chat, error) {
+				return env.service.AddMember(ctx, "alice", "g", "bob")
+			},
+			wantErr: domain.ErrAlreadyMember,
+		},
+		{
+			name: "add an unknown user",
+			change: func(ctx context.Context, env testEnv) (domain.Chat, error) {
+				return env.service.AddMember(ctx, "alice", "g", "erin")
+			},
+			wantErr: domain.ErrUserNotFound,
+		},
+		{
+			name: "add a banned user",
+			setup: func(ctx context.Context, env testEnv) error {
+				_, err := env.service.RemoveMember(ctx, "alice", "g", "bob", true)
+				return err
+			},
+			change: func(ctx context.Context, env testEnv) (domain.Chat, error) {
+				return env.service.AddMember(ctx, "alice", "g", "bob")
+			},
+			wantErr: domain.ErrUserBanned,
+		},
+		{
+			name: "add an unbanned user",
+			setup: func(ctx context.Context, env testEnv) error {
+				if _, err := env.service.RemoveMember(ctx, "alice", "g", "bob", true); err != nil {
+					return err
+				}
+				_, err := env.service.UnbanMember(ctx, "alice", "g", "bob")
+				return err
+			},
+			change: func(ctx context.Context, env testEnv) (domain.Chat, error) {
+				return env.service.AddMember(ctx, "alice", "g", "bob")
+			},
+			check: func(t *testing.T, chat domain.Chat) {
+				if len(chat.Banned) > 0 {
+					t.Errorf("still banned: %v", chat.Banned)
+				}
+			},
+		},
+		{
+			name: "ban a member",
+			change: func(ctx context.Context, env testEnv) (domain.Chat, error) {
+				return env.service.RemoveMember(ctx, "alice", "g", "bob", true)
+			},
+			check: func(t *testing.T, chat domain.Chat) {
+				if slices.Contains(chat.Members, "bob") || !slices.Contains(chat.Banned, "bob") {
+					t.Errorf("got members %v and banned %v, want bob banned only", chat.Members, chat.Banned)
+				}
+			},
+		},
+		{
+			name: "remove a user who is no member",
+			change: func(ctx context.Context, env testEnv) (domain.Chat, error) {
+				return env.service.RemoveMember(ctx, "alice", "g", "dave", false)
+			},
+			wantErr: domain.ErrUserNotInChat,
+		},
+		{
+			name: "admin removes the owner",
+			setup: func(ctx context.Context, env testEnv) error {
+				_, err := env.service.SetMemberRole(ctx, "alice", "g", "bob", domain.RoleAdmin)
+				return err
+			},
+			change: func(ctx context.Context, env testEnv) (domain.Chat, error) {
+				return env.service.RemoveMember(ctx, "bob", "g", "alice", false)
+			},
+			wantErr: domain.ErrNotAllowed,
+		},
+		{
+			name: "admin promotes a member",
+			setup: func(ctx context.Context, env testEnv) error {
+				_, err := env.service.SetMemberRole(ctx, "alice", "g", "bob", domain.RoleAdmin)
+				return err
+			},
+			change: func(ctx context.Context, env testEnv) (domain.Chat, error) {
+				return env.service.SetMemberRole(ctx, "bob", "g", "carol", domain.RoleAdmin)
+			},
+			wantErr: domain.ErrNotAllowed,
+		},
+		{
+			name: "unban a user who is not banned",
+			change: func(ctx context.Context, env testEnv) (domain.Chat, error) {
+				return env.service.UnbanMember(ctx, "alice", "g", "dave")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			env := newTestEnv(t)
+			if tt.setup != nil {
+				if err := tt.setup(ctx, env); err != nil {
+					t.Fatalf("setup: %v", err)
+				}
+			}
+
+			chat, err := tt.change(ctx, env)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			stored, err := env.service.GetChat(ctx, "alice", "g")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if chat.Version != stored.Version {
+				t.Errorf("got version %d, stored is %d", chat.Version, stored.Version)
+			}
+			if tt.check != nil {
+				tt.check(t, stored)
+			}
+		})
+	}
+}
+
+func TestLeaveChatPassesOwnership(t *testing.T) {
+	tests := []struct {
+		name      string
+		admin     string
+		wantOwner string
+	}{
+		{name: "to the first admin", admin: "carol", wantOwner: "carol"},
+		{name: "to the first member", wantOwner: "bob"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			env := newTestEnv(t)
+			if tt.admin != "" {
+				if _, err := env.service.SetMemberRole(ctx, "alice", "g", tt.admin, domain.RoleAdmin); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := env.service.LeaveChat(ctx, "alice", "g"); err != nil {
+				t.Fatal(err)
+			}
+			chat, err := env.service.GetChat(ctx, "bob", "g")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if chat.Owner != tt.wantOwner {
+				t.Errorf("got owner %q, want %q", chat.Owner, tt.wantOwner)
+			}
+		})
+	}
+}
+
+// TestConcurrentMemberChanges runs updates that race each other and checks
+// that none of them is lost or decided on a stale chat
+func TestConcurrentMemberChanges(t *testing.T) {
+	t.Run("ban and add", func(t *testing.T) {
+		for i := range 50 {
+			ctx := context.Background()
+			env := newTestEnv(t)
+			if _, err := env.service.RemoveMember(ctx, "alice", "g", "carol", false); err != nil {
+				t.Fatal(err)
+			}
+
+			var wg sync.WaitGroup
+			var banErr, addErr error
+			wg.Go(func() { _, banErr = env.service.RemoveMember(ctx, "alice", "g", "carol", true) })
+			wg.Go(func() { _, addErr = env.service.AddMember(ctx, "alice", "g", "carol") })
+			wg.Wait()
+
+			if banErr != nil {
+				t.Fatalf("run %d: ban: %v", i, banErr)
+			}
+			if addErr != nil && !errors.Is(addErr, domain.ErrUserBanned) {
+				t.Fatalf("run %d: add: %v", i, addErr)
+			}
+			chat, err := env.service.GetChat(ctx, "alice", "g")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if slices.Contains(chat.Members, "carol") {
+				t.Fatalf("run %d: carol is a member although banned: %v", i, chat.Members)
+			}
+		}
+	})
+
+	t.Run("many adds", func(t *testing.T) {
+		ctx := context.Background()
+		env := newTestEnv(t)
+
+		const n = 20
+		users := make([]string, n)
+		for i := range users {
+			users[i] = fmt.Sprintf("user%d", i)
+			if err := env.users.Create(ctx, domain.User{ID: users[i], Name: users[i]}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var wg sync.WaitGroup
+		errs := make([]error, n)
+		for i, id := range users {
+			wg.Go(func() { _, errs[i] = env.service.AddMember(ctx, "alice", "g", id) })
+		}
+		wg.Wait()
+
+		chat, err := env.service.GetChat(ctx, "alice", "g")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, id := range users {
+			switch {
+			case errs[i] == nil && !slices.Contains(chat.Members, id):
+				t.Errorf("adding %s succeeded but was lost", id)
+			case errs[i] != nil && !errors.Is(errs[i], domain.ErrChatChanged):
+				t.Errorf("adding %s: %v", id, errs[i])
+			}
+		}
+	})
+}
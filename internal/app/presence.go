@@ -0,0 +1,160 @@
+package app
+
+import (
+	"cligram/internal/domain"
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// PresenceChanged is published when a user comes online or goes offline. It
+// does not belong to a single chat: it is meant for Contacts, everyone who
+// shares a chat with the user.
+type PresenceChanged struct {
+	Presence domain.Presence
+	Contacts []string
+}
+
+func (e PresenceChanged) ChatID() string { return "" }
+
+// ConnectionCounter counts the live connections of each user. The default one
+// only sees the connections to this server instance; instances that share
+// their users need a shared one, or a user connected to two of them would go
+// offline as soon as they left either.
+type ConnectionCounter interface {
+	// Add records a new connection and reports whether it is the user's first
+	Add(ctx context.Context, userID string) (first bool, err error)
+	// Remove records a closed connection and reports whether it was the last
+	Remove(ctx context.Context, userID string) (last bool, err error)
+	Online(ctx context.Context, userID string) (bool, error)
+}
+
+// presenceTracker is the ConnectionCounter of a single instance
+type presenceTracker struct {
+	mu          sync.Mutex
+	connections map[string]int
+}
+
+func newPresenceTracker() *presenceTracker {
+	return &presenceTracker{connections: make(map[string]int)}
+}
+
+// Add implements ConnectionCounter
+func (t *presenceTracker) Add(_ context.Context, userID string) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.connections[userID]++
+	return t.connections[userID] == 1, nil
+}
+
+// Remove implements ConnectionCounter
+func (t *presenceTracker) Remove(_ context.Context, userID string) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.connections[userID] == 0 {
+		return false, nil
+	}
+	t.connections[userID]--
+	if t.connections[userID] > 0 {
+		return false, nil
+	}
+	delete(t.connections, userID)
+	return true, nil
+}
+
+// Online implements ConnectionCounter
+func (t *presenceTracker) Online(_ context.Context, userID string) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.connections[userID] > 0, nil
+}
+
+// SetConnectionCounter makes the service count connections with c instead of
+// on its own, e.g. to share the count between server instances
+func (s *ChatService) SetConnectionCounter(c ConnectionCounter) {
+	s.presence = c
+}
+
+// UserConnected records a new live connection of userID, who comes online
+// with their first one
+func (s *ChatService) UserConnected(ctx context.Context, userID string) error {
+	if _, err := s.users.GetByID(ctx, userID); err != nil {
+		return err
+	}
+	first, err := s.presence.Add(ctx, userID)
+	if err != nil || !first {
+		return err
+	}
+	return s.publishPresence(ctx, domain.Presence{UserID: userID, Online: true})
+}
+
+// UserDisconnected records that a connection of userID closed. When it was
+// their last one they go offline and their last seen time is stored.
+func (s *ChatService) UserDisconnected(ctx context.Context, userID string) error {
+	last, err := s.presence.Remove(ctx, userID)
+	if err != nil || !last {
+		return err
+	}
+
+	now := time.Now()
+	if err := s.users.SetLastSeen(ctx, userID, now); err != nil {
+		return err
+	}
+	return s.publishPresence(ctx, domain.Presence{UserID: userID, LastSeen: &now})
+}
+
+// Presence tells whether userID is online and when they were last seen. The
+// caller only learns it for themselves and users they share a chat with.
+func (s *ChatService) Presence(ctx context.Context, callerID, userID string) (domain.Presence, error) {
+	user, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return domain.Presence{}, err
+	}
+	if callerID != userID {
+		contacts, err := s.contactsOf(ctx, userID)
+		if err != nil {
+			return domain.Presence{}, err
+		}
+		if !slices.Contains(contacts, callerID) {
+			return domain.Presence{}, domain.ErrNotAllowed
+		}
+	}
+	online, err := s.presence.Online(ctx, userID)
+	if err != nil {
+		return domain.Presence{}, err
+	}
+	if online {
+		return domain.Presence{UserID: userID, Online: true}, nil
+	}
+	return domain.Presence{UserID: userID, LastSeen: user.LastSeen}, nil
+}
+
+func (s *ChatService) publishPresence(ctx context.Context, presence domain.Presence) error {
+	contacts, err := s.contactsOf(ctx, presence.UserID)
+	if err != nil {
+		return err
+	}
+	s.events.Publish(PresenceChanged{Presence: presence, Contacts: contacts})
+	return nil
+}
+
+// contactsOf returns everyone who shares a chat with userID
+func (s *ChatService) contactsOf(ctx context.Context, userID string) ([]string, error) {
+	chats, err := s.chats.ListByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var contacts []string
+	for _, chat := range chats {
+		for _, member := range chat.Members {
+			if member != userID && !slices.Contains(contacts, member) {
+				contacts = append(contacts, member)
+			}
+		}
+	}
+	return contacts, nil
+}
@@ -0,0 +1,41 @@
+package app_test
+
+import (
+	"cligram/internal/domain"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestPresence(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t)
+	if err := env.service.UserConnected(ctx, "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		callerID   string
+		userID     string
+		wantOnline bool
+		wantErr    error
+	}{
+		{name: "member of a shared chat", callerID: "bob", userID: "alice", wantOnline: true},
+		{name: "offline contact", callerID: "alice", userID: "carol"},
+		{name: "oneself", callerID: "dave", userID: "dave"},
+		{name: "no shared chat", callerID: "dave", userID: "alice", wantErr: domain.ErrNotAllowed},
+		{name: "unknown user", callerID: "bob", userID: "erin", wantErr: domain.ErrUserNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			presence, err := env.service.Presence(ctx, tt.callerID, tt.userID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if presence.Online != tt.wantOnline {
+				t.Errorf("got online %t, want %t", presence.Online, tt.wantOnline)
+			}
+		})
+	}
+}
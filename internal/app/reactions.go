@@ -0,0 +1,106 @@
+package app
+
+import (
+	"cligram/internal/domain"
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const maxEmojiLength = 32
+
+// ReactionChanged is published after a reaction was added to or removed from
+// a message; Message carries the updated reactions
+type ReactionChanged struct {
+	Message  domain.Message
+	Reaction domain.Reaction
+	Removed  bool
+}
+
+func (e ReactionChanged) ChatID() string { return e.Message.ChatID }
+
+// AddReaction reacts to a message on behalf of userID. Reacting again with
+// the same emoji takes the reaction back, so added tells which of the two
+// happened.
+func (s *ChatService) AddReaction(
+	ctx context.Context,
+	userID string,
+	messageID string,
+	emoji string,
+) (msg domain.Message, added bool, err error) {
+	msg, removed, err := s.changeReaction(ctx, userID, messageID, emoji, false)
+	return msg, !removed, err
+}
+
+// RemoveReaction takes back userID's reaction with emoji, if there is one
+func (s *ChatService) RemoveReaction(
+	ctx context.Context,
+	userID string,
+	messageID string,
+	emoji string,
+) (domain.Message, error) {
+	msg, _, err := s.changeReaction(ctx, userID, messageID, emoji, true)
+	return msg, err
+}
+
+// ListReactions returns a message's reactions grouped by emoji
+func (s *ChatService) ListReactions(
+	ctx context.Context,
+	userID string,
+	messageID string,
+) ([]domain.ReactionCount, error) {
+	msg, err := s.messages.GetByID(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.requireMember(ctx, userID, msg.ChatID); err != nil {
+		return nil, err
+	}
+	return msg.ReactionCounts(), nil
+}
+
+// changeReaction adds or removes a reaction and reports whether it ended up
+// removing it, which an add does when the reaction is there already
+func (s *ChatService) changeReaction(
+	ctx context.Context,
+	userID string,
+	messageID string,
+	emoji string,
+	remove bool,
+) (domain.Message, bool, error) {
+	if emoji == "" || utf8.RuneCountInString(emoji) > maxEmojiLength || strings.ContainsFunc(emoji, unicode.IsSpace) {
+		return domain.Message{}, false, fmt.Errorf("%w: reaction must be a single emoji or short code", domain.ErrInvalidInput)
+	}
+
+	msg, err := s.messages.GetByID(ctx, messageID)
+	if err != nil {
+		return domain.Message{}, false, err
+	}
+	if _, err := s.requireMember(ctx, userID, msg.ChatID); err != nil {
+		return domain.Message{}, false, err
+	}
+
+	reaction := domain.Reaction{UserID: userID, Emoji: emoji}
+	var changed bool
+	if !remove {
+		msg, changed, err = s.messages.AddReaction(ctx, messageID, reaction)
+		if err != nil {
+			return domain.Message{}, false, err
+		}
+		remove = !changed
+	}
+	if remove {
+		msg, changed, err = s.messages.RemoveReaction(ctx, messageID, reaction)
+		if err != nil {
+			return domain.Message{}, false, err
+		}
+	}
+	if !changed {
+		return msg, remove, nil
+	}
+
+	s.events.Publish(ReactionChanged{Message: msg, Reaction: reaction, Removed: remove})
+	return msg, remove, nil
+}
@@ -0,0 +1,106 @@
+package app_test
+
+import (
+	"cligram/internal/app"
+	"cligram/internal/domain"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestReactions(t *testing.T) {
+	type step struct {
+		userID string
+		emoji  string
+		remove bool
+	}
+	tests := []struct {
+		name       string
+		steps      []step
+		wantErr    error
+		want       []domain.ReactionCount
+		wantEvents []bool // Removed of each ReactionChanged published
+	}{
+		{
+			name:       "add",
+			steps:      []step{{userID: "bob", emoji: "👍"}},
+			want:       []domain.ReactionCount{{Emoji: "👍", Count: 1, UserIDs: []string{"bob"}}},
+			wantEvents: []bool{false},
+		},
+		{
+			name:  "add by several users",
+			steps: []step{{userID: "bob", emoji: "👍"}, {userID: "carol", emoji: "👍"}, {userID: "bob", emoji: "🎉"}},
+			want: []domain.ReactionCount{
+				{Emoji: "👍", Count: 2, UserIDs: []string{"bob", "carol"}},
+				{Emoji: "🎉", Count: 1, UserIDs: []string{"bob"}},
+			},
+			wantEvents: []bool{false, false, false},
+		},
+		{
+			name:       "add again toggles off",
+			steps:      []step{{userID: "bob", emoji: "👍"}, {userID: "bob", emoji: "👍"}},
+			wantEvents: []bool{false, true},
+		},
+		{
+			name:       "remove",
+			steps:      []step{{userID: "bob", emoji: "👍"}, {userID: "bob", emoji: "👍", remove: true}},
+			wantEvents: []bool{false, true},
+		},
+		{
+			name:  "remove missing",
+			steps: []step{{userID: "bob", emoji: "👍", remove: true}},
+		},
+		{
+			name:    "not an emoji",
+			steps:   []step{{userID: "bob", emoji: "two words"}},
+			wantErr: domain.ErrInvalidInput,
+		},
+		{
+			name:    "not a member",
+			steps:   []step{{userID: "dave", emoji: "👍"}},
+			wantErr: domain.ErrUserNotInChat,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			env := newTestEnv(t)
+			msg, err := env.service.SendMessage(ctx, "alice", "g", "hi", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			published := env.events.count()
+
+			for _, s := range tt.steps {
+				if s.remove {
+					_, err = env.service.RemoveReaction(ctx, s.userID, msg.ID, s.emoji)
+				} else {
+					_, _, err = env.service.AddReaction(ctx, s.userID, msg.ID, s.emoji)
+				}
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			counts, err := env.service.ListReactions(ctx, "alice", msg.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(counts, tt.want) {
+				t.Errorf("got reactions %+v, want %+v", counts, tt.want)
+			}
+
+			var events []bool
+			for _, e := range env.events.since(published) {
+				if rc, ok := e.(app.ReactionChanged); ok {
+					events = append(events, rc.Removed)
+				}
+			}
+			if !reflect.DeepEqual(events, tt.wantEvents) {
+				t.Errorf("got events removing %v, want %v", events, tt.wantEvents)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package app
+
+import (
+	"cligram/internal/domain"
+	"context"
+	"time"
+)
+
+// ReadReceiptChanged is published after a user has read further in a chat
+type ReadReceiptChanged struct {
+	Receipt domain.ReadReceipt
+}
+
+func (e ReadReceiptChanged) ChatID() string { return e.Receipt.ChatID }
+
+// MarkRead records that userID has read the chat up to and including
+// messageID, or up to its latest message if messageID is empty. Read
+// positions only move forward; the returned receipt is the one asked for
+// even if the user had already read further.
+func (s *ChatService) MarkRead(
+	ctx context.Context,
+	userID string,
+	chatID string,
+	messageID string,
+) (domain.ReadReceipt, error) {
+	if _, err := s.requireMember(ctx, userID, chatID); err != nil {
+		return domain.ReadReceipt{}, err
+	}
+
+	var msg domain.Message
+	if messageID == "" {
+		latest, err := s.messages.ListByChat(ctx, chatID, domain.MessagePage{Limit: 1})
+		if err != nil {
+			return domain.ReadReceipt{}, err
+		}
+		if len(latest) == 0 {
+			return domain.ReadReceipt{UserID: userID, ChatID: chatID}, nil
+		}
+		msg = latest[0]
+	} else {
+		var err error
+		if msg, err = s.messages.GetByID(ctx, messageID); err != nil {
+			return domain.ReadReceipt{}, err
+		}
+		if msg.ChatID != chatID {
+			return domain.ReadReceipt{}, domain.ErrMessageNotFound
+		}
+	}
+
+	receipt := domain.ReadReceipt{
+		UserID: userID,
+		ChatID: chatID,
+		Seq:    msg.Seq,
+		ReadAt: time.Now(),
+	}
+	advanced, err := s.receipts.Advance(ctx, receipt)
+	if err != nil {
+		return domain.ReadReceipt{}, err
+	}
+
+	if advanced {
+		s.events.Publish(ReadReceiptChanged{Receipt: receipt})
+	}
+	return receipt, nil
+}
+
+// ReadReceipts returns how far each member who has read anything in the
+// chat got
+func (s *ChatService) ReadReceipts(ctx context.Context, userID, chatID string) ([]domain.ReadReceipt, error) {
+	if _, err := s.requireMember(ctx, userID, chatID); err != nil {
+		return nil, err
+	}
+	return s.receipts.ListByChat(ctx, chatID)
+}
+
+// ListUserChatsWithUnread is ListUserChats with the number of messages from
+// others each chat has that userID has not read yet
+func (s *ChatService) ListUserChatsWithUnread(ctx context.Context, userID string) ([]domain.UserChat, error) {
+	chats, err := s.ListUserChats(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	receipts, err := s.receipts.ListByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	readSeq := make(map[string]int64, len(receipts))
+	for _, r := range receipts {
+		readSeq[r.ChatID] = r.Seq
+	}
+
+	userChats := make([]domain.UserChat, 0, len(chats))
+	for _, chat := range chats {
+		unread, err := s.messages.CountUnread(ctx, chat.ID, userID, readSeq[chat.ID])
+		if err != nil {
+			return nil, err
+		}
+		userChats = append(userChats, domain.UserChat{Chat: chat, UnreadCount: unread})
+	}
+	return userChats, nil
+}
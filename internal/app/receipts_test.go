@@ -0,0 +1,107 @@
+package app_test
+
+import (
+	"cligram/internal/app"
+	"cligram/internal/domain"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestMarkRead(t *testing.T) {
+	tests := []struct {
+		name string
+		// reads are the Seqs of the messages bob marks read in turn; 0 marks
+		// the latest one read
+		reads      []int64
+		userID     string
+		chatID     string
+		wantErr    error
+		wantSeq    int64 // bob's stored position
+		wantUnread int
+		wantEvents int
+	}{
+		{name: "nothing read", wantUnread: 4},
+		{name: "up to a message", reads: []int64{2}, wantSeq: 2, wantUnread: 2, wantEvents: 1},
+		{name: "up to the latest", reads: []int64{0}, wantSeq: 5, wantUnread: 0, wantEvents: 1},
+		{name: "own messages are not unread", reads: []int64{3}, wantSeq: 3, wantUnread: 1, wantEvents: 1},
+		{name: "never backwards", reads: []int64{3, 1}, wantSeq: 3, wantUnread: 1, wantEvents: 1},
+		{name: "forwards again", reads: []int64{1, 3}, wantSeq: 3, wantUnread: 1, wantEvents: 2},
+		{name: "message of another chat", reads: []int64{2}, chatID: "dm", wantErr: domain.ErrMessageNotFound, wantUnread: 4},
+		{name: "not a member", reads: []int64{2}, userID: "dave", wantErr: domain.ErrUserNotInChat, wantUnread: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			env := newTestEnv(t)
+			dm, _, err := env.service.DirectChat(ctx, "alice", "bob")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// alice sends 1 to 3 and 5, bob 4
+			var sent []domain.Message
+			for i, from := range []string{"alice", "alice", "alice", "bob", "alice"} {
+				msg, err := env.service.SendMessage(ctx, from, "g", "hi", "")
+				if err != nil {
+					t.Fatalf("send %d: %v", i+1, err)
+				}
+				sent = append(sent, msg)
+			}
+			published := env.events.count()
+
+			userID, chatID := "bob", "g"
+			if tt.userID != "" {
+				userID = tt.userID
+			}
+			if tt.chatID == "dm" {
+				chatID = dm.ID
+			}
+			for _, seq := range tt.reads {
+				messageID := ""
+				if seq > 0 {
+					messageID = sent[seq-1].ID
+				}
+				_, err = env.service.MarkRead(ctx, userID, chatID, messageID)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			receipts, err := env.service.ReadReceipts(ctx, "alice", "g")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var seq int64
+			for _, r := range receipts {
+				if r.UserID == "bob" {
+					seq = r.Seq
+				}
+			}
+			if seq != tt.wantSeq {
+				t.Errorf("got bob at Seq %d, want %d", seq, tt.wantSeq)
+			}
+
+			chats, err := env.service.ListUserChatsWithUnread(ctx, "bob")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, c := range chats {
+				if c.Chat.ID == "g" && c.UnreadCount != tt.wantUnread {
+					t.Errorf("got %d unread, want %d", c.UnreadCount, tt.wantUnread)
+				}
+			}
+
+			events := 0
+			for _, e := range env.events.since(published) {
+				if _, ok := e.(app.ReadReceiptChanged); ok {
+					events++
+				}
+			}
+			if events != tt.wantEvents {
+				t.Errorf("got %d receipt events, want %d", events, tt.wantEvents)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package app
+
+import (
+	"cligram/internal/domain"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+)
+
+// ParseSearchQuery turns a search string into a MessageSearch. Besides plain
+// words it understands these filters:
+//
+//	from:<user>     messages sent by user
+//	in:<chat>       messages in chat; may be repeated
+//	before:<date>   messages sent before date
+//	after:<date>    messages sent on or after date
+//
+// Dates are either 2006-01-02 (midnight UTC) or RFC 3339 timestamps. Every
+// word must appear in a message for it to match; how words compare depends
+// on the storage backend, see the Search method of each MessageRepository.
+func ParseSearchQuery(raw string) (domain.MessageSearch, error) {
+	var q domain.MessageSearch
+	var words []string
+
+	for _, field := range strings.Fields(raw) {
+		key, value, ok := strings.Cut(field, ":")
+		if !ok || value == "" {
+			words = append(words, field)
+			continue
+		}
+
+		switch key {
+		case "from":
+			q.From = value
+		case "in":
+			q.ChatIDs = append(q.ChatIDs, value)
+		case "before", "after":
+			t, err := parseSearchDate(value)
+			if err != nil {
+				return domain.MessageSearch{}, fmt.Errorf("%w: %s date %q is neither YYYY-MM-DD nor an RFC 3339 timestamp", domain.ErrInvalidInput, key, value)
+			}
+			if key == "before" {
+				q.Before = t
+			} else {
+				q.After = t
+			}
+		default:
+			// not a filter, e.g. "re:" or a URL
+			words = append(words, field)
+		}
+	}
+
+	q.Text = strings.Join(words, " ")
+	return q, nil
+}
+
+func parseSearchDate(value string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// SearchMessages runs a search query (see ParseSearchQuery) over the chats
+// userID is a member of. Naming a chat with in: that the user is not a member
+// of is an error rather than an empty result.
+func (s *ChatService) SearchMessages(
+	ctx context.Context,
+	userID string,
+	rawQuery string,
+	limit int,
+) ([]domain.Message, error) {
+	query, err := ParseSearchQuery(rawQuery)
+	if err != nil {
+		return nil, err
+	}
+	if query.Text == "" && query.From == "" && query.Before.IsZero() && query.After.IsZero() {
+		return nil, fmt.Errorf("%w: search query needs words or a from:, before: or after: filter", domain.ErrInvalidInput)
+	}
+
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+	query.Limit = min(limit, MaxSearchLimit)
+
+	chats, err := s.ListUserChats(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	memberOf := make([]string, 0, len(chats))
+	for _, c := range chats {
+		memberOf = append(memberOf, c.ID)
+	}
+
+	if query.ChatIDs == nil {
+		query.ChatIDs = memberOf
+	} else {
+		for _, chatID := range query.ChatIDs {
+			if !slices.Contains(memberOf, chatID) {
+				return nil, domain.ErrUserNotInChat
+			}
+		}
+	}
+
+	if len(query.ChatIDs) == 0 {
+		return nil, nil
+	}
+	return s.messages.Search(ctx, query)
+}
@@ -0,0 +1,94 @@
+package app_test
+
+import (
+	"cligram/internal/app"
+	"cligram/internal/domain"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    domain.MessageSearch
+		wantErr error
+	}{
+		{name: "words", raw: "lunch  today", want: domain.MessageSearch{Text: "lunch today"}},
+		{
+			name: "filters",
+			raw:  "from:bob in:g in:h lunch",
+			want: domain.MessageSearch{Text: "lunch", From: "bob", ChatIDs: []string{"g", "h"}},
+		},
+		{
+			name: "dates",
+			raw:  "after:2026-01-02 before:2026-03-04T05:06:07Z",
+			want: domain.MessageSearch{
+				After:  time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC),
+				Before: time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC),
+			},
+		},
+		{name: "unknown filter is a word", raw: "re:lunch", want: domain.MessageSearch{Text: "re:lunch"}},
+		{name: "empty filter is a word", raw: "from:", want: domain.MessageSearch{Text: "from:"}},
+		{name: "bad date", raw: "before:yesterday", wantErr: domain.ErrInvalidInput},
+		{name: "date without day", raw: "after:2026-01", wantErr: domain.ErrInvalidInput},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := app.ParseSearchQuery(tt.raw)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchMessages(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t)
+	for _, m := range []struct{ from, text string }{
+		{"alice", "Lunch today?"},
+		{"bob", "lunch at noon"},
+		{"bob", "lunches are great"},
+	} {
+		if _, err := env.service.SendMessage(ctx, m.from, "g", m.text, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		userID  string
+		query   string
+		want    []string
+		wantErr error
+	}{
+		{name: "word in any case", userID: "carol", query: "LUNCH", want: []string{"lunch at noon", "Lunch today?"}},
+		{name: "every word", userID: "carol", query: "lunch noon", want: []string{"lunch at noon"}},
+		{name: "from", userID: "carol", query: "from:alice lunch", want: []string{"Lunch today?"}},
+		{name: "not a member", userID: "dave", query: "lunch"},
+		{name: "in a chat of others", userID: "dave", query: "in:g lunch", wantErr: domain.ErrUserNotInChat},
+		{name: "no words or filters", userID: "carol", query: "in:g", wantErr: domain.ErrInvalidInput},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgs, err := env.service.SearchMessages(ctx, tt.userID, tt.query, 0)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			var got []string
+			for _, m := range msgs {
+				got = append(got, m.Text)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
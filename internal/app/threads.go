@@ -0,0 +1,65 @@
+package app
+
+import (
+	"cligram/internal/domain"
+	"cmp"
+	"context"
+	"fmt"
+)
+
+// ThreadUpdated is published after a reply was added to the thread Root
+// starts; Root carries the new ReplyCount
+type ThreadUpdated struct {
+	Root domain.Message
+}
+
+func (e ThreadUpdated) ChatID() string { return e.Root.ChatID }
+
+// ReplyToMessage sends a message to the chat of replyToID as a reply to it.
+// The reply joins the thread of the message it answers, or starts one.
+// chatID may be left empty; if it is not, it must be the chat of replyToID.
+func (s *ChatService) ReplyToMessage(
+	ctx context.Context,
+	fromUserID string,
+	chatID string,
+	replyToID string,
+	text string,
+	clientID string,
+) (domain.Message, error) {
+	parent, err := s.messages.GetByID(ctx, replyToID)
+	if err != nil {
+		return domain.Message{}, err
+	}
+	if chatID != "" && chatID != parent.ChatID {
+		return domain.Message{}, fmt.Errorf("%w: message %s is not in chat %s", domain.ErrInvalidInput, replyToID, chatID)
+	}
+	if _, err := s.requireMember(ctx, fromUserID, parent.ChatID); err != nil {
+		return domain.Message{}, err
+	}
+	if parent.Deleted() {
+		return domain.Message{}, domain.ErrMessageDeleted
+	}
+
+	msg := newMessage(fromUserID, parent.ChatID, text, clientID)
+	msg.ReplyTo = parent.ID
+	msg.ThreadRoot = cmp.Or(parent.ThreadRoot, parent.ID)
+	return s.storeMessage(ctx, msg)
+}
+
+// Thread returns the thread messageID belongs to, starting with its root and
+// followed by the replies in order
+func (s *ChatService) Thread(
+	ctx context.Context,
+	userID string,
+	messageID string,
+) ([]domain.Message, error) {
+	msg, err := s.messages.GetByID(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.requireMember(ctx, userID, msg.ChatID); err != nil {
+		return nil, err
+	}
+
+	return s.messages.ListThread(ctx, cmp.Or(msg.ThreadRoot, msg.ID))
+}
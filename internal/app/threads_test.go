@@ -0,0 +1,92 @@
+package app_test
+
+import (
+	"cligram/internal/app"
+	"cligram/internal/domain"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestReplyToMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		chatID  string
+		deleted bool // whether the parent is deleted first
+		wantErr error
+	}{
+		{name: "same chat", chatID: "g"},
+		{name: "chat left out", chatID: ""},
+		{name: "other chat", chatID: "other", wantErr: domain.ErrInvalidInput},
+		{name: "deleted parent", chatID: "g", deleted: true, wantErr: domain.ErrMessageDeleted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			env := newTestEnv(t)
+			root, err := env.service.SendMessage(ctx, "alice", "g", "root", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.deleted {
+				if _, err := env.service.DeleteMessage(ctx, "alice", root.ID); err != nil {
+					t.Fatal(err)
+				}
+			}
+			published := env.events.count()
+
+			reply, err := env.service.ReplyToMessage(ctx, "bob", tt.chatID, root.ID, "reply", "")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if reply.ThreadRoot != root.ID || reply.ChatID != "g" {
+				t.Errorf("got thread root %q in chat %q, want %q in g", reply.ThreadRoot, reply.ChatID, root.ID)
+			}
+
+			var updated []domain.Message
+			for _, e := range env.events.since(published) {
+				if e, ok := e.(app.ThreadUpdated); ok {
+					updated = append(updated, e.Root)
+				}
+			}
+			if len(updated) != 1 || updated[0].ID != root.ID || updated[0].ReplyCount != 1 {
+				t.Errorf("got thread updates %+v, want the root with one reply", updated)
+			}
+		})
+	}
+}
+
+func TestThread(t *testing.T) {
+	ctx := context.Background()
+	env := newTestEnv(t)
+	root, err := env.service.SendMessage(ctx, "alice", "g", "root", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := env.service.ReplyToMessage(ctx, "bob", "g", root.ID, "reply", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a reply to a reply joins the thread of the root
+	if _, err := env.service.ReplyToMessage(ctx, "carol", "g", reply.ID, "nested", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{root.ID, reply.ID} {
+		thread, err := env.service.Thread(ctx, "carol", id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var texts []string
+		for _, m := range thread {
+			texts = append(texts, m.Text)
+		}
+		if len(texts) != 3 || texts[0] != "root" || thread[0].ReplyCount != 2 {
+			t.Errorf("thread of %s: got %q with %d replies counted, want root, reply, nested with 2",
+				id, texts, thread[0].ReplyCount)
+		}
+	}
+}
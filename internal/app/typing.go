@@ -0,0 +1,104 @@
+package app
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// TypingTimeout is how long a typing indicator lasts unless the client
+// renews it with another StartTyping
+const TypingTimeout = 6 * time.Second
+
+// TypingChanged is published when a member starts or stops typing in a chat.
+// It is not stored anywhere.
+type TypingChanged struct {
+	// Chat is the ID of the chat, which the ChatID method returns
+	Chat   string
+	UserID string
+	Typing bool
+}
+
+func (e TypingChanged) ChatID() string { return e.Chat }
+
+type typingKey struct {
+	chatID string
+	userID string
+}
+
+// typingTracker remembers who is typing where and expires indicators that
+// were not renewed within timeout
+type typingTracker struct {
+	timeout time.Duration
+
+	mu     sync.Mutex
+	timers map[typingKey]*time.Timer
+}
+
+func newTypingTracker() *typingTracker {
+	return &typingTracker{timeout: TypingTimeout, timers: make(map[typingKey]*time.Timer)}
+}
+
+// start (re)arms the expiry for key and reports whether key was not typing
+// before. expire runs if the indicator is not renewed or stopped in time.
+func (t *typingTracker) start(key typingKey, expire func()) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	old, wasTyping := t.timers[key]
+	if wasTyping {
+		old.Stop()
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(t.timeout, func() {
+		t.mu.Lock()
+		current := t.timers[key] == timer
+		if current {
+			delete(t.timers, key)
+		}
+		t.mu.Unlock()
+
+		if current {
+			expire()
+		}
+	})
+	t.timers[key] = timer
+	return !wasTyping
+}
+
+// stop clears key and reports whether it was typing
+func (t *typingTracker) stop(key typingKey) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	timer, ok := t.timers[key]
+	if ok {
+		timer.Stop()
+		delete(t.timers, key)
+	}
+	return ok
+}
+
+// StartTyping marks userID as typing in the chat for TypingTimeout
+func (s *ChatService) StartTyping(ctx context.Context, userID, chatID string) error {
+	if _, err := s.requireMember(ctx, userID, chatID); err != nil {
+		return err
+	}
+
+	key := typingKey{chatID: chatID, userID: userID}
+	started := s.typing.start(key, func() {
+		s.events.Publish(TypingChanged{Chat: chatID, UserID: userID, Typing: false})
+	})
+	if started {
+		s.events.Publish(TypingChanged{Chat: chatID, UserID: userID, Typing: true})
+	}
+	return nil
+}
+
+// StopTyping ends the typing indicator of userID in the chat, if any
+func (s *ChatService) StopTyping(userID, chatID string) {
+	if s.typing.stop(typingKey{chatID: chatID, userID: userID}) {
+		s.events.Publish(TypingChanged{Chat: chatID, UserID: userID, Typing: false})
+	}
+}
@@ -0,0 +1,86 @@
+package app_test
+
+import (
+	"cligram/internal/app"
+	"cligram/internal/domain"
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestTyping(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+
+	tests := []struct {
+		name string
+		// steps are "start", "stop", "send" or a duration to wait
+		steps   []any
+		userID  string
+		wantErr error
+		want    []bool // Typing of each TypingChanged published
+	}{
+		{name: "start", steps: []any{"start"}, want: []bool{true}},
+		{name: "start twice", steps: []any{"start", "start"}, want: []bool{true}},
+		{name: "stop", steps: []any{"start", "stop"}, want: []bool{true, false}},
+		{name: "stop without start", steps: []any{"stop"}},
+		{name: "expire", steps: []any{"start", 2 * timeout}, want: []bool{true, false}},
+		{name: "stop before expiry", steps: []any{"start", "stop", 2 * timeout}, want: []bool{true, false}},
+		{
+			name:  "renew before expiry",
+			steps: []any{"start", timeout * 6 / 10, "start", timeout * 6 / 10},
+			want:  []bool{true},
+		},
+		{
+			name:  "expire after renewal",
+			steps: []any{"start", timeout * 6 / 10, "start", 2 * timeout},
+			want:  []bool{true, false},
+		},
+		{name: "send a message", steps: []any{"start", "send"}, want: []bool{true, false}},
+		{name: "not a member", steps: []any{"start"}, userID: "dave", wantErr: domain.ErrUserNotInChat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			env := newTestEnv(t)
+			app.SetTypingTimeout(env.service, timeout)
+			published := env.events.count()
+
+			userID := "bob"
+			if tt.userID != "" {
+				userID = tt.userID
+			}
+			var err error
+			for _, step := range tt.steps {
+				switch step {
+				case "start":
+					err = env.service.StartTyping(ctx, userID, "g")
+				case "stop":
+					env.service.StopTyping(userID, "g")
+				case "send":
+					_, err = env.service.SendMessage(ctx, userID, "g", "hi", "")
+				default:
+					time.Sleep(step.(time.Duration))
+				}
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			var got []bool
+			for _, e := range env.events.since(published) {
+				if e, ok := e.(app.TypingChanged); ok {
+					if e.ChatID() != "g" || e.UserID != userID {
+						t.Errorf("got typing of %s in %s, want %s in g", e.UserID, e.ChatID(), userID)
+					}
+					got = append(got, e.Typing)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got typing events %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
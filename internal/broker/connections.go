@@ -0,0 +1,136 @@
+package broker
+
+import (
+	"cligram/internal/db"
+	"context"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// connectionTTL is how long the connections an instance counted outlive it
+// if it stops without cleaning up; Run renews them well before that
+const connectionTTL = time.Minute
+
+// queryTimeout bounds every query the broker package makes
+const queryTimeout = 5 * time.Second
+
+// Connections is an app.ConnectionCounter shared by every instance using the
+// same database. Each instance keeps a count per user in the connections
+// collection, in a document with the _id "<instance>/<user ID>"; a user is
+// online while the counts add up to more than zero.
+type Connections struct {
+	instance   string
+	collection *mongo.Collection
+}
+
+func NewConnections(client *mongo.Client) *Connections {
+	return &Connections{
+		instance:   uuid.NewString(),
+		collection: client.Database("cligram-db").Collection(string(db.ConnectionsCollection)),
+	}
+}
+
+func (c *Connections) docID(userID string) string {
+	return c.instance + "/" + userID
+}
+
+// Add implements app.ConnectionCounter
+func (c *Connections) Add(ctx context.Context, userID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	_, err := c.collection.UpdateByID(ctx, c.docID(userID), bson.M{
+		"$inc": bson.M{"count": 1},
+		"$set": bson.M{"user_id": userID, "instance": c.instance, "expires_at": time.Now().Add(connectionTTL)},
+	}, options.Update().SetUpsert(true))
+	if err != nil {
+		return false, err
+	}
+	total, err := c.total(ctx, userID)
+	return total == 1, err
+}
+
+// Remove implements app.ConnectionCounter
+func (c *Connections) Remove(ctx context.Context, userID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	id := c.docID(userID)
+	res, err := c.collection.UpdateOne(ctx, bson.M{"_id": id, "count": bson.M{"$gt": 0}}, bson.M{"$inc": bson.M{"count": -1}})
+	if err != nil {
+		return false, err
+	}
+	if res.MatchedCount == 0 {
+		return false, nil
+	}
+	if _, err := c.collection.DeleteOne(ctx, bson.M{"_id": id, "count": bson.M{"$lte": 0}}); err != nil {
+		return false, err
+	}
+	total, err := c.total(ctx, userID)
+	return total == 0, err
+}
+
+// Online implements app.ConnectionCounter
+func (c *Connections) Online(ctx context.Context, userID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	total, err := c.total(ctx, userID)
+	return total > 0, err
+}
+
+// total adds up the connections of a user to every live instance. Counts
+// past their expiry are left out even before the TTL monitor removes them.
+func (c *Connections) total(ctx context.Context, userID string) (int, error) {
+	cursor, err := c.collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"user_id": userID, "expires_at": bson.M{"$gt": time.Now()}}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "total": bson.M{"$sum": "$count"}}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var result struct {
+		Total int `bson:"total"`
+	}
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&result); err != nil {
+			return 0, err
+		}
+	}
+	return result.Total, cursor.Err()
+}
+
+// Run keeps the counts of this instance alive until ctx is done, then
+// removes them so its users do not look online after it stopped.
+func (c *Connections) Run(ctx context.Context) error {
+	ticker := time.NewTicker(connectionTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			cleanup, cancel := context.WithTimeout(context.WithoutCancel(ctx), queryTimeout)
+			defer cancel()
+			if _, err := c.collection.DeleteMany(cleanup, bson.M{"instance": c.instance}); err != nil {
+				log.Printf("Could not remove the connections of this instance: %v", err)
+			}
+			return ctx.Err()
+
+		case <-ticker.C:
+			renew, cancel := context.WithTimeout(ctx, queryTimeout)
+			_, err := c.collection.UpdateMany(renew, bson.M{"instance": c.instance},
+				bson.M{"$set": bson.M{"expires_at": time.Now().Add(connectionTTL)}})
+			cancel()
+			if err != nil {
+				log.Printf("Could not renew the connections of this instance: %v", err)
+			}
+		}
+	}
+}
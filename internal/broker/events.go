@@ -0,0 +1,91 @@
+package broker
+
+import (
+	"cligram/internal/app"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// eventTTL is how long an event stays in the events collection; instances
+// only need it until their change stream has read it
+const eventTTL = time.Minute
+
+// eventDoc is a document of the events collection: an event one instance
+// published for all of them
+type eventDoc struct {
+	Kind      string    `bson:"kind"`
+	Event     bson.Raw  `bson:"event"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// Kinds of shared events
+const (
+	kindMemberAdded       = "member_added"
+	kindMemberRemoved     = "member_removed"
+	kindMemberRoleChanged = "member_role_changed"
+	kindReadReceipt       = "read_receipt"
+	kindTyping            = "typing"
+	kindPresence          = "presence"
+)
+
+// encodeEvent turns an event that is not about stored messages into a
+// document of the events collection
+func encodeEvent(e app.Event) (eventDoc, error) {
+	var kind string
+	switch e.(type) {
+	case app.MemberAdded:
+		kind = kindMemberAdded
+	case app.MemberRemoved:
+		kind = kindMemberRemoved
+	case app.MemberRoleChanged:
+		kind = kindMemberRoleChanged
+	case app.ReadReceiptChanged:
+		kind = kindReadReceipt
+	case app.TypingChanged:
+		kind = kindTyping
+	case app.PresenceChanged:
+		kind = kindPresence
+	default:
+		return eventDoc{}, fmt.Errorf("cannot share %T events", e)
+	}
+
+	raw, err := bson.Marshal(e)
+	if err != nil {
+		return eventDoc{}, err
+	}
+	return eventDoc{Kind: kind, Event: raw, ExpiresAt: time.Now().Add(eventTTL)}, nil
+}
+
+// decodeEvent is the reverse of encodeEvent
+func decodeEvent(doc eventDoc) (app.Event, error) {
+	var e app.Event
+	var err error
+	switch doc.Kind {
+	case kindMemberAdded:
+		e, err = unmarshal[app.MemberAdded](doc.Event)
+	case kindMemberRemoved:
+		e, err = unmarshal[app.MemberRemoved](doc.Event)
+	case kindMemberRoleChanged:
+		e, err = unmarshal[app.MemberRoleChanged](doc.Event)
+	case kindReadReceipt:
+		e, err = unmarshal[app.ReadReceiptChanged](doc.Event)
+	case kindTyping:
+		e, err = unmarshal[app.TypingChanged](doc.Event)
+	case kindPresence:
+		e, err = unmarshal[app.PresenceChanged](doc.Event)
+	default:
+		return nil, fmt.Errorf("unknown event kind %q", doc.Kind)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s event: %w", doc.Kind, err)
+	}
+	return e, nil
+}
+
+func unmarshal[T any](raw bson.Raw) (T, error) {
+	var v T
+	err := bson.Unmarshal(raw, &v)
+	return v, err
+}
@@ -0,0 +1,91 @@
+package broker
+
+import (
+	"cligram/internal/app"
+	"cligram/internal/domain"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEventRoundTrip(t *testing.T) {
+	// BSON keeps milliseconds, in UTC
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	chat := domain.Chat{
+		ID:        "g",
+		Type:      domain.ChatTypeGroup,
+		Name:      "g",
+		CreatedAt: now,
+		CreatedBy: "alice",
+		Members:   []string{"alice", "bob"},
+		Owner:     "alice",
+		Admins:    []string{"bob"},
+		Banned:    []string{"carol"},
+		Version:   3,
+	}
+
+	tests := []struct {
+		name     string
+		event    app.Event
+		wantKind string
+	}{
+		{name: "member added", event: app.MemberAdded{Chat: chat, UserID: "bob", By: "alice"}, wantKind: kindMemberAdded},
+		{
+			name:     "member removed",
+			event:    app.MemberRemoved{Chat: chat, UserID: "carol", By: "alice", Banned: true},
+			wantKind: kindMemberRemoved,
+		},
+		{
+			name:     "member role changed",
+			event:    app.MemberRoleChanged{Chat: chat, UserID: "bob", Role: domain.RoleAdmin},
+			wantKind: kindMemberRoleChanged,
+		},
+		{
+			name:     "read receipt",
+			event:    app.ReadReceiptChanged{Receipt: domain.ReadReceipt{UserID: "bob", ChatID: "g", Seq: 7, ReadAt: now}},
+			wantKind: kindReadReceipt,
+		},
+		{name: "typing", event: app.TypingChanged{Chat: "g", UserID: "bob", Typing: true}, wantKind: kindTyping},
+		{
+			name:     "online",
+			event:    app.PresenceChanged{Presence: domain.Presence{UserID: "bob", Online: true}, Contacts: []string{"alice"}},
+			wantKind: kindPresence,
+		},
+		{
+			name:     "offline",
+			event:    app.PresenceChanged{Presence: domain.Presence{UserID: "bob", LastSeen: &now}, Contacts: []string{"alice"}},
+			wantKind: kindPresence,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := encodeEvent(tt.event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if doc.Kind != tt.wantKind {
+				t.Errorf("got kind %q, want %q", doc.Kind, tt.wantKind)
+			}
+
+			got, err := decodeEvent(doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.event) {
+				t.Errorf("got %+v, want %+v", got, tt.event)
+			}
+			if got.ChatID() != tt.event.ChatID() {
+				t.Errorf("got chat %q, want %q", got.ChatID(), tt.event.ChatID())
+			}
+		})
+	}
+}
+
+func TestEventNotShared(t *testing.T) {
+	if _, err := encodeEvent(app.MessageCreated{}); err == nil {
+		t.Error("encoded a message event, which the change stream of messages shares")
+	}
+	if _, err := decodeEvent(eventDoc{Kind: "nope"}); err == nil {
+		t.Error("decoded an event of an unknown kind")
+	}
+}
@@ -0,0 +1,263 @@
+// Package broker holds app.Broker implementations that let several server
+// instances share live traffic, and an app.ConnectionCounter that lets them
+// share presence. app.EventBus is the in-process broker.
+package broker
+
+import (
+	"cligram/internal/app"
+	"cligram/internal/db"
+	"cligram/internal/domain"
+	"context"
+	"errors"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// retryDelay bounds the wait before reopening a failed change stream
+const retryDelay = 30 * time.Second
+
+// ChangeStream is an app.Broker that takes message events from a MongoDB
+// change stream on the messages collection instead of from the publisher.
+// Every instance watching the same database sees every change, so a message
+// stored through one instance reaches the subscribers of all of them.
+//
+// Events that are not about stored messages (typing, presence, members, read
+// receipts) are written to the events collection instead, which every
+// instance watches too. The two streams are not ordered with respect to each
+// other, so a message may arrive before the member event preceding it.
+//
+// Change streams need a replica set. To tell which reaction was removed the
+// collection keeps pre-images, which needs MongoDB 6.0 or later; without them
+// only added reactions are reported.
+type ChangeStream struct {
+	local      *app.EventBus
+	collection *mongo.Collection
+	events     *mongo.Collection
+}
+
+func NewChangeStream(client *mongo.Client) *ChangeStream {
+	database := client.Database("cligram-db")
+	return &ChangeStream{
+		local:      app.NewEventBus(),
+		collection: database.Collection(string(db.MessagesCollection)),
+		events:     database.Collection(string(db.EventsCollection)),
+	}
+}
+
+// Publish implements app.Broker. Message events are dropped here: they come
+// back through the change stream. Other events are written to the events
+// collection and come back through its change stream; if that fails they
+// still reach this instance's subscribers.
+func (b *ChangeStream) Publish(e app.Event) {
+	switch e.(type) {
+	case app.MessageCreated, app.MessageEdited, app.MessageDeleted, app.ReactionChanged, app.ThreadUpdated:
+		return
+	}
+
+	doc, err := encodeEvent(e)
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+		defer cancel()
+		_, err = b.events.InsertOne(ctx, doc)
+	}
+	if err != nil {
+		log.Printf("Could not share %T with other instances, delivering it here only: %v", e, err)
+		b.local.Publish(e)
+	}
+}
+
+func (b *ChangeStream) Subscribe(h app.EventHandler) (unsubscribe func()) {
+	return b.local.Subscribe(h)
+}
+
+// Run turns changes to the messages collection and inserts into the events
+// collection into events until ctx is done. A failed stream is reopened
+// where it left off.
+func (b *ChangeStream) Run(ctx context.Context) error {
+	b.enablePreImages(ctx)
+
+	errs := make(chan error, 1)
+	go func() { errs <- follow(ctx, "Event", b.watchEvents) }()
+	err := follow(ctx, "Message", b.watchMessages)
+	return errors.Join(err, <-errs)
+}
+
+// follow keeps a change stream open with watch until ctx is done
+func follow(ctx context.Context, name string, watch func(ctx context.Context, resumeToken bson.Raw) (bson.Raw, error)) error {
+	var resumeToken bson.Raw
+	delay := time.Second
+	for {
+		token, err := watch(ctx, resumeToken)
+		if token != nil {
+			resumeToken = token
+			delay = time.Second
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Printf("%s change stream failed, reopening in %v: %v", name, delay, err)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay = min(delay*2, retryDelay)
+	}
+}
+
+// enablePreImages makes MongoDB keep the previous version of changed
+// messages, which is how removed reactions are told apart
+func (b *ChangeStream) enablePreImages(ctx context.Context) {
+	err := b.collection.Database().RunCommand(ctx, bson.D{
+		{Key: "collMod", Value: b.collection.Name()},
+		{Key: "changeStreamPreAndPostImages", Value: bson.D{{Key: "enabled", Value: true}}},
+	}).Err()
+	if err != nil {
+		log.Printf("Could not enable pre-images on %s, removed reactions will not be reported: %v", b.collection.Name(), err)
+	}
+}
+
+// messageChange is the part of a change event the broker looks at
+type messageChange struct {
+	OperationType            string          `bson:"operationType"`
+	FullDocument             *domain.Message `bson:"fullDocument"`
+	FullDocumentBeforeChange *domain.Message `bson:"fullDocumentBeforeChange"`
+	UpdateDescription        struct {
+		UpdatedFields bson.Raw `bson:"updatedFields"`
+		RemovedFields []string `bson:"removedFields"`
+	} `bson:"updateDescription"`
+}
+
+// watchMessages publishes events for changes to messages after resumeToken,
+// or from now on if it is nil, until the stream fails. It returns the token
+// of the last change handled.
+func (b *ChangeStream) watchMessages(ctx context.Context, resumeToken bson.Raw) (bson.Raw, error) {
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.M{
+		"operationType": bson.M{"$in": bson.A{"insert", "update", "replace"}},
+	}}}}
+	opts := options.ChangeStream().
+		SetFullDocument(options.UpdateLookup).
+		SetFullDocumentBeforeChange(options.WhenAvailable)
+	if resumeToken != nil {
+		opts.SetResumeAfter(resumeToken)
+	}
+
+	stream, err := b.collection.Watch(ctx, pipeline, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close(context.WithoutCancel(ctx))
+
+	var last bson.Raw
+	for stream.Next(ctx) {
+		var change messageChange
+		if err := stream.Decode(&change); err != nil {
+			log.Printf("Skipping undecodable message change: %v", err)
+		} else {
+			for _, e := range change.events() {
+				b.local.Publish(e)
+			}
+		}
+		last = stream.ResumeToken()
+	}
+	return last, stream.Err()
+}
+
+// watchEvents is watchMessages for the events collection
+func (b *ChangeStream) watchEvents(ctx context.Context, resumeToken bson.Raw) (bson.Raw, error) {
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.M{"operationType": "insert"}}}}
+	opts := options.ChangeStream()
+	if resumeToken != nil {
+		opts.SetResumeAfter(resumeToken)
+	}
+
+	stream, err := b.events.Watch(ctx, pipeline, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close(context.WithoutCancel(ctx))
+
+	var last bson.Raw
+	for stream.Next(ctx) {
+		var change struct {
+			FullDocument eventDoc `bson:"fullDocument"`
+		}
+		err := stream.Decode(&change)
+		var e app.Event
+		if err == nil {
+			e, err = decodeEvent(change.FullDocument)
+		}
+		if err != nil {
+			log.Printf("Skipping undecodable event: %v", err)
+		} else {
+			b.local.Publish(e)
+		}
+		last = stream.ResumeToken()
+	}
+	return last, stream.Err()
+}
+
+// events tells what happened to a message from a change to its document
+func (c messageChange) events() []app.Event {
+	msg := c.FullDocument
+	if msg == nil {
+		return nil // gone again by the time the change was read
+	}
+	if c.OperationType == "insert" {
+		return []app.Event{app.MessageCreated{Message: *msg}}
+	}
+
+	changed := func(field string) bool {
+		if _, err := c.UpdateDescription.UpdatedFields.LookupErr(field); err == nil {
+			return true
+		}
+		return slices.Contains(c.UpdateDescription.RemovedFields, field)
+	}
+
+	if changed("deleted_at") && msg.DeletedAt != nil {
+		return []app.Event{app.MessageDeleted{Message: *msg}}
+	}
+
+	var events []app.Event
+	if changed("edited_at") {
+		events = append(events, app.MessageEdited{Message: *msg})
+	}
+	if changed("reply_count") {
+		events = append(events, app.ThreadUpdated{Root: *msg})
+	}
+
+	if before := c.FullDocumentBeforeChange; before != nil {
+		for _, r := range msg.Reactions {
+			if !slices.Contains(before.Reactions, r) {
+				events = append(events, app.ReactionChanged{Message: *msg, Reaction: r})
+			}
+		}
+		for _, r := range before.Reactions {
+			if !slices.Contains(msg.Reactions, r) {
+				events = append(events, app.ReactionChanged{Message: *msg, Reaction: r, Removed: true})
+			}
+		}
+		return events
+	}
+
+	// without a pre-image only reactions appended to an existing list, which
+	// show up as "reactions.<index>", can be told
+	elements, _ := c.UpdateDescription.UpdatedFields.Elements()
+	for _, field := range elements {
+		if !strings.HasPrefix(field.Key(), "reactions.") {
+			continue
+		}
+		var r domain.Reaction
+		if err := field.Value().Unmarshal(&r); err == nil {
+			events = append(events, app.ReactionChanged{Message: *msg, Reaction: r})
+		}
+	}
+	return events
+}
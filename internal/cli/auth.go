@@ -0,0 +1,228 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// credentials are what login leaves behind for later commands
+type credentials struct {
+	// Server is the host:port the token was issued by; it is only ever sent
+	// back there
+	Server    string    `json:"server"`
+	UserID    string    `json:"user_id"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// creds are the saved credentials, empty when not logged in
+var creds credentials
+
+func init() {
+	if saved, err := loadCredentials(); err == nil && time.Now().Before(saved.ExpiresAt) {
+		creds = saved
+		serverURL = "http://" + creds.Server
+	}
+}
+
+// httpClient sends every request of the CLI. It adds the saved token to
+// those for the server that issued it.
+var httpClient = &http.Client{Transport: authTransport{base: http.DefaultTransport}}
+
+// authTransport adds the saved token to requests for the server it came from
+type authTransport struct {
+	base http.RoundTripper
+}
+
+func (t authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if creds.Token != "" && req.URL.Host == creds.Server && req.Header.Get("Authorization") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("Authorization", authHeader())
+	}
+	return t.base.RoundTrip(req)
+}
+
+func authHeader() string {
+	return "Bearer " + creds.Token
+}
+
+// requireLogin tells the user to log in first if they have not
+func requireLogin() bool {
+	if creds.Token == "" {
+		fmt.Println("Not logged in. Run: cligram login <user_id>")
+		return false
+	}
+	return true
+}
+
+func credentialsPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "cligram", "credentials.json"), nil
+}
+
+func loadCredentials() (credentials, error) {
+	path, err := credentialsPath()
+	if err != nil {
+		return credentials{}, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return credentials{}, err
+	}
+
+	var c credentials
+	err = json.Unmarshal(data, &c)
+	return c, err
+}
+
+func saveCredentials(c credentials) error {
+	path, err := credentialsPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	// the token is as good as the password, keep it private. WriteFile
+	// would keep the mode of an existing file, so write a new one, which
+	// CreateTemp makes 0600, and move it over the old.
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".credentials-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func removeCredentials() error {
+	path, err := credentialsPath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// readPassword prompts for a password, without echoing it on a terminal
+func readPassword(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		password, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return string(password), err
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// LoginCmd exchanges a password for a token and saves it for later commands
+func LoginCmd(args []string) {
+	fs := flag.NewFlagSet("login", flag.ExitOnError)
+	server := fs.String("server", "localhost:8080", "server address")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Println("Usage: cligram login [-server host:port] <user_id>")
+		return
+	}
+	userID := fs.Arg(0)
+
+	password, err := readPassword("Password: ")
+	if err != nil {
+		fmt.Println("Failed to read password:", err)
+		return
+	}
+
+	reqBody, _ := json.Marshal(map[string]string{"user_id": userID, "password": password})
+	loginURL := (&url.URL{Scheme: "http", Host: *server, Path: "/login"}).String()
+	resp, err := httpClient.Post(loginURL, "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		fmt.Println("Request error:", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		fmt.Println("Login failed:", resp.Status)
+		return
+	}
+
+	var login struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&login); err != nil {
+		fmt.Println("Decode error:", err)
+		return
+	}
+
+	err = saveCredentials(credentials{
+		Server:    *server,
+		UserID:    userID,
+		Token:     login.Token,
+		ExpiresAt: login.ExpiresAt,
+	})
+	if err != nil {
+		fmt.Println("Failed to save credentials:", err)
+		return
+	}
+	fmt.Printf("Logged in as %s until %s\n", userID, login.ExpiresAt.Format("2006-01-02"))
+}
+
+// LogoutCmd revokes the saved token and forgets it
+func LogoutCmd(args []string) {
+	if creds.Token == "" {
+		fmt.Println("Not logged in")
+		return
+	}
+
+	resp, err := httpClient.Post(serverURL+"/logout", "application/json", nil)
+	if err != nil {
+		fmt.Println("Request error:", err)
+	} else {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusUnauthorized {
+			fmt.Println("Error:", resp.Status)
+		}
+	}
+
+	// forget the token even if the server could not be told
+	if err := removeCredentials(); err != nil {
+		fmt.Println("Failed to remove credentials:", err)
+		return
+	}
+	fmt.Printf("Logged out %s\n", creds.UserID)
+}
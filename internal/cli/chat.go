@@ -3,12 +3,16 @@ package cli
 import (
 	"flag"
 	"fmt"
+	"net/url"
 	"strings"
 )
 
 func ChatCmd(args []string) {
 	if len(args) < 1 {
-		fmt.Println("Expected chat subcommand: create")
+		fmt.Println("Expected chat subcommand: create, add-member, remove-member")
+		return
+	}
+	if !requireLogin() {
 		return
 	}
 
@@ -17,6 +21,8 @@ func ChatCmd(args []string) {
 		fs := flag.NewFlagSet("create", flag.ExitOnError)
 		id := fs.String("id", "", "chat ID")
 		members := fs.String("members", "", "comma-separated user IDs")
+		name := fs.String("name", "", "chat name")
+		description := fs.String("description", "", "chat description")
 		fs.Parse(args[1:])
 
 		if *id == "" || *members == "" {
@@ -25,7 +31,43 @@ func ChatCmd(args []string) {
 		}
 
 		memberList := strings.Split(*members, ",")
-		postJSON(serverURL, "/chats", map[string]interface{}{"id": *id, "members": memberList})
+		postJSON(serverURL, "/chats", map[string]interface{}{
+			"id":          *id,
+			"members":     memberList,
+			"name":        *name,
+			"description": *description,
+		})
+
+	case "add-member":
+		fs := flag.NewFlagSet("add-member", flag.ExitOnError)
+		id := fs.String("id", "", "chat ID")
+		member := fs.String("member", "", "user ID to add")
+		fs.Parse(args[1:])
+
+		if *id == "" || *member == "" {
+			fmt.Println("id and member are required")
+			return
+		}
+
+		postJSON(serverURL, "/chats/"+url.PathEscape(*id)+"/members", map[string]interface{}{"member_id": *member})
+
+	case "remove-member":
+		fs := flag.NewFlagSet("remove-member", flag.ExitOnError)
+		id := fs.String("id", "", "chat ID")
+		member := fs.String("member", "", "user ID to remove; your own ID leaves the chat")
+		ban := fs.Bool("ban", false, "also ban the member from the chat (kick)")
+		fs.Parse(args[1:])
+
+		if *id == "" || *member == "" {
+			fmt.Println("id and member are required")
+			return
+		}
+
+		query := url.Values{}
+		if *ban {
+			query.Set("ban", "true")
+		}
+		deleteRequest(serverURL, "/chats/"+url.PathEscape(*id)+"/members/"+url.PathEscape(*member)+"?"+query.Encode())
 
 	default:
 		fmt.Println("Unknown chat subcommand:", args[0])
@@ -0,0 +1,46 @@
+package cli
+
+import (
+	"bytes"
+	"cligram/internal/domain"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// DmCmd finds or creates your direct chat with another user and prints its ID
+func DmCmd(args []string) {
+	if len(args) < 1 {
+		fmt.Println("Usage: cligram dm <other_user>")
+		return
+	}
+	if !requireLogin() {
+		return
+	}
+
+	reqBody, _ := json.Marshal(map[string]string{"other_id": args[0]})
+
+	resp, err := httpClient.Post(serverURL+"/chats/direct", "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		fmt.Println("Request error:", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		fmt.Println("Error:", resp.Status)
+		return
+	}
+
+	var chat domain.Chat
+	if err := json.NewDecoder(resp.Body).Decode(&chat); err != nil {
+		fmt.Println("Decode error:", err)
+		return
+	}
+
+	if resp.StatusCode == http.StatusCreated {
+		fmt.Printf("Started a direct chat with %s: %s\n", args[0], chat.ID)
+	} else {
+		fmt.Printf("Direct chat with %s: %s\n", args[0], chat.ID)
+	}
+}
@@ -17,7 +17,28 @@ func postJSON(baseURL, endpoint string, data interface{}) {
 	}
 
 	url := baseURL + endpoint
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(reqBody))
+	resp, err := httpClient.Post(url, "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		fmt.Println("Request error:", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		fmt.Println("Success")
+	} else {
+		fmt.Println("Error:", resp.Status)
+	}
+}
+
+func deleteRequest(baseURL, endpoint string) {
+	req, err := http.NewRequest(http.MethodDelete, baseURL+endpoint, nil)
+	if err != nil {
+		fmt.Println("Request error:", err)
+		return
+	}
+
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		fmt.Println("Request error:", err)
 		return
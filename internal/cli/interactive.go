@@ -2,16 +2,27 @@ package cli
 
 import (
 	"bufio"
+	"bytes"
 	"cligram/internal/domain"
+	"cligram/internal/protocol"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"maps"
 	"net/http"
+	"net/url"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"golang.org/x/term"
 )
 
 // DisplayManager interface for output handling (adapter pattern)
@@ -20,6 +31,8 @@ type DisplayManager interface {
 	ShowError(err string)
 	ShowPrompt()
 	ShowIncomingMessage(from, chatID, text string)
+	// ShowStatus replaces the transient status, e.g. who is typing; "" clears it
+	ShowStatus(status string)
 }
 
 // ConsoleDisplay implements DisplayManager for terminal output
@@ -41,77 +54,638 @@ func (c *ConsoleDisplay) ShowIncomingMessage(from, chatID, text string) {
 	fmt.Printf("\n[%s] %s: %s\n> ", chatID, from, text)
 }
 
+// ShowStatus prints a new status on its own line, since a plain console
+// cannot update one in place
+func (c *ConsoleDisplay) ShowStatus(status string) {
+	if status != "" {
+		fmt.Printf("\n(%s)\n> ", status)
+	}
+}
+
 // InteractiveSession manages the interactive CLI session
 type InteractiveSession struct {
-	userID      string
-	serverAddr  string
-	conn        *websocket.Conn
-	display     DisplayManager
+	userID     string
+	serverAddr string
+	display    DisplayManager
+	scanner    *bufio.Scanner
+
+	// conn is replaced by the listener when it reconnects, and both the
+	// listener and the command loop write to it; writeMu guards it and
+	// connectionID
+	writeMu sync.Mutex
+	conn    *websocket.Conn
+	// connectionID is how the server tells this session apart from the
+	// user's others
+	connectionID string
+
+	// resumeToken and lastEvent, the last event numbered by the server, let
+	// the listener resume the session after losing the connection. Only the
+	// listener uses them once it runs.
+	resumeToken string
+	lastEvent   int64
+	// syncRequest is the request ID of the last sync, whose ack means we
+	// caught up
+	syncRequest string
+
+	// pending holds the messages we sent that the server has neither
+	// acknowledged nor echoed back yet, by client ID. They are sent again after a reconnect; the
+	// server drops the copies of those that got through.
+	pendingMu sync.Mutex
+	pending   map[string]pendingMessage
+
+	// currentChat is only changed by the command loop, through
+	// setCurrentChat; the listener reads it under chatMu
+	chatMu      sync.RWMutex
 	currentChat string
-	scanner     *bufio.Scanner
+
+	// oldestShown is the ID of the oldest message of currentChat shown so
+	// far; /history more pages back from it
+	oldestShown string
+
+	// lastSeq is the newest message seq seen per chat, used to notice
+	// messages that never reached us
+	seqMu   sync.Mutex
+	lastSeq map[string]int64
+
+	// shown is the most recent message listing; commands such as /edit refer
+	// to its entries by their 1-based index
+	shown []domain.Message
+
+	// typingSent is when we last told the server we are typing in
+	// currentChat, zero if we are not typing
+	typingSent time.Time
+	// typing holds who else is typing in currentChat
+	typingMu sync.Mutex
+	typing   []string
 }
 
-// WSMessage represents WebSocket message structure
-type WSMessage struct {
-	Type   string `json:"type"`   // "message", "subscribe", "unsubscribe"
-	ChatID string `json:"chat_id"`
-	Text   string `json:"text"`
+type pendingMessage struct {
+	req    protocol.Envelope
+	sentAt time.Time
 }
 
-// InteractiveChat starts the interactive CLI session
-func InteractiveChat(userID, serverAddr string) {
+// InteractiveChat starts the interactive CLI session as the logged in user.
+// serverAddr defaults to the server they logged in to.
+func InteractiveChat(serverAddr string) {
+	if !requireLogin() {
+		return
+	}
+	if serverAddr == "" {
+		serverAddr = creds.Server
+	}
+	if serverAddr != creds.Server {
+		fmt.Printf("Logged in to %s; run: cligram login -server %s <user_id>\n", creds.Server, serverAddr)
+		return
+	}
+
 	session := &InteractiveSession{
-		userID:     userID,
+		userID:     creds.UserID,
 		serverAddr: serverAddr,
 		display:    &ConsoleDisplay{},
 		scanner:    bufio.NewScanner(os.Stdin),
+		lastSeq:    make(map[string]int64),
+		pending:    make(map[string]pendingMessage),
 	}
 
-	if err := session.connect(); err != nil {
+	if _, err := session.connect(); err != nil {
 		log.Fatalf("Connection failed: %v", err)
 	}
 	defer session.conn.Close()
 
+	// on a real terminal we edit the input line ourselves, which keeps it
+	// intact while messages arrive and lets us notice typing
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		if display, err := NewTerminalDisplay(fd); err == nil {
+			display.OnKey(session.noteTyping)
+			session.display = display
+			defer display.Close()
+		}
+	}
+
 	session.display.ShowMessage("Connected! Type /help for available commands")
 	session.startMessageListener()
 	session.commandLoop()
 }
 
-func (s *InteractiveSession) connect() error {
-	url := fmt.Sprintf("ws://%s/ws?user_id=%s", s.serverAddr, s.userID)
-	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+const (
+	// reconnectTimeout is how long we keep trying to get back to the server
+	reconnectTimeout = 5 * time.Minute
+	// maxReconnectDelay caps the backoff between reconnect attempts
+	maxReconnectDelay = 30 * time.Second
+)
+
+// connect dials the server, asking to resume our session if we had one, and
+// reports whether it was resumed
+func (s *InteractiveSession) connect() (bool, error) {
+	query := url.Values{}
+	if s.resumeToken != "" {
+		query.Set("resume_token", s.resumeToken)
+		query.Set("last_event", strconv.FormatInt(s.lastEvent, 10))
+	}
+	wsURL := fmt.Sprintf("ws://%s/ws?%s", s.serverAddr, query.Encode())
+	dialer := *websocket.DefaultDialer
+	dialer.Subprotocols = protocol.Subprotocols()
+	conn, resp, err := dialer.Dial(wsURL, http.Header{"Authorization": {authHeader()}})
 	if err != nil {
-		return err
+		if resp != nil {
+			// the server says why, e.g. that it does not speak our version
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+			resp.Body.Close()
+			if reason := strings.TrimSpace(string(body)); reason != "" {
+				return false, fmt.Errorf("%w: %s", err, reason)
+			}
+		}
+		return false, err
+	}
+	if _, ok := protocol.ParseSubprotocol(conn.Subprotocol()); !ok {
+		conn.Close()
+		return false, errors.New("server did not agree on a protocol version")
+	}
+
+	// the server introduces the connection first
+	var env protocol.Envelope
+	var hello protocol.Connected
+	if err := conn.ReadJSON(&env); err != nil {
+		conn.Close()
+		return false, err
+	}
+	if err := env.Decode(&hello); err != nil || env.Type != protocol.TypeConnected {
+		conn.Close()
+		return false, fmt.Errorf("expected a connected frame, got %s", env.Type)
+	}
+	if !hello.Resumed {
+		s.lastEvent = 0
+	}
+	s.resumeToken = hello.ResumeToken
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if s.conn != nil {
+		s.conn.Close()
 	}
 	s.conn = conn
-	return nil
+	s.connectionID = hello.ConnectionID
+	return hello.Resumed, nil
+}
+
+// reconnect dials the server again after losing the connection, backing off
+// between attempts, and gives up after reconnectTimeout. Unless the session
+// could be resumed, the current chat is synced from the last message we saw.
+func (s *InteractiveSession) reconnect() bool {
+	s.display.ShowError("Connection lost, reconnecting...")
+
+	delay := time.Second
+	for deadline := time.Now().Add(reconnectTimeout); time.Now().Before(deadline); {
+		time.Sleep(delay)
+		delay = min(delay*2, maxReconnectDelay)
+
+		resumed, err := s.connect()
+		if err != nil {
+			continue
+		}
+		if resumed {
+			s.display.ShowMessage("Reconnected")
+			s.resendPending()
+			return true
+		}
+
+		s.display.ShowMessage("Reconnected, catching up...")
+		if chatID := s.getCurrentChat(); chatID != "" {
+			s.seqMu.Lock()
+			positions := map[string]int64{chatID: s.lastSeq[chatID]}
+			s.seqMu.Unlock()
+			s.syncRequest = uuid.NewString()
+			if err := s.request(s.syncRequest, protocol.TypeSync, protocol.Sync{Positions: positions}); err != nil {
+				continue
+			}
+		}
+		s.resendPending()
+		return true
+	}
+	return false
 }
 
 func (s *InteractiveSession) startMessageListener() {
 	go func() {
 		for {
-			var msg domain.Message
-			if err := s.conn.ReadJSON(&msg); err != nil {
+			s.writeMu.Lock()
+			conn := s.conn
+			s.writeMu.Unlock()
+
+			var env protocol.Envelope
+			if err := conn.ReadJSON(&env); err != nil {
+				if s.reconnect() {
+					continue
+				}
 				s.display.ShowError("Disconnected from server")
-				os.Exit(0)
+				s.exit()
+			}
+			if env.Seq > 0 {
+				s.lastEvent = env.Seq
+			}
+			if err := s.handleEvent(env); err != nil {
+				s.display.ShowError(err.Error())
 			}
-			s.display.ShowIncomingMessage(msg.From, msg.ChatID, msg.Text)
 		}
 	}()
 }
 
+// handleEvent shows a frame pushed by the server
+func (s *InteractiveSession) handleEvent(env protocol.Envelope) error {
+	switch env.Type {
+	case protocol.TypeAck:
+		if env.RequestID == s.syncRequest {
+			var result protocol.SyncResult
+			if err := env.Decode(&result); err != nil {
+				return err
+			}
+			for _, failed := range result.Failed {
+				s.display.ShowError(fmt.Sprintf("[%s] Could not catch up: %s", failed.ChatID, failed.Message))
+			}
+			s.display.ShowMessage("Caught up")
+			return nil
+		}
+		if !s.forgetPending(env.RequestID) {
+			return nil
+		}
+		var sent protocol.MessageEvent
+		if err := env.Decode(&sent); err != nil {
+			return err
+		}
+		if !s.isCurrentChat(sent.Message.ChatID) {
+			s.display.ShowMessage("Message sent to " + sent.Message.ChatID)
+		}
+
+	case protocol.TypeError:
+		if env.Error == nil {
+			return nil
+		}
+		text := env.Error.Message
+		if s.forgetPending(env.RequestID) {
+			text = "Message not sent: " + text
+		}
+		if env.Error.ChatID != "" {
+			text = fmt.Sprintf("[%s] %s", env.Error.ChatID, text)
+		}
+		s.display.ShowError(text)
+
+	case protocol.TypeMissed:
+		var missed protocol.Missed
+		if err := env.Decode(&missed); err != nil {
+			return err
+		}
+		for _, msg := range missed.Messages {
+			s.confirmSent(msg)
+			if _, fresh := s.trackSeq(msg.ChatID, msg.Seq); fresh {
+				s.showNewMessage(msg)
+			}
+		}
+
+	case protocol.TypeMessage:
+		var e protocol.MessageEvent
+		if err := env.Decode(&e); err != nil {
+			return err
+		}
+		msg := e.Message
+		s.confirmSent(msg)
+		missed, fresh := s.trackSeq(msg.ChatID, msg.Seq)
+		if !fresh {
+			// replayed by a sync already
+			return nil
+		}
+		if missed > 0 {
+			s.catchUp(msg.ChatID, msg.Seq-missed-1, msg.Seq)
+		}
+		s.showNewMessage(msg)
+
+	case protocol.TypeMessageEdited, protocol.TypeMessageDeleted:
+		var e protocol.MessageEvent
+		if err := env.Decode(&e); err != nil {
+			return err
+		}
+		text := e.Message.Text + " (edited)"
+		if env.Type == protocol.TypeMessageDeleted {
+			text = "(deleted a message)"
+		}
+		s.display.ShowIncomingMessage(e.Message.From, e.Message.ChatID, text)
+
+	case protocol.TypeReaction:
+		var e protocol.ReactionEvent
+		if err := env.Decode(&e); err != nil {
+			return err
+		}
+		action := "reacted " + e.Reaction.Emoji + " to"
+		if e.Removed {
+			action = "took back " + e.Reaction.Emoji + " on"
+		}
+		s.display.ShowIncomingMessage(e.Reaction.UserID, e.Message.ChatID,
+			fmt.Sprintf("(%s %q)", action, truncate(e.Message.Text, 30)))
+
+	case protocol.TypeMemberAdded, protocol.TypeMemberRemoved, protocol.TypeMemberRoleChanged:
+		var e protocol.MemberEvent
+		if err := env.Decode(&e); err != nil {
+			return err
+		}
+		s.showMemberEvent(env.Type, e)
+
+	case protocol.TypePresence:
+		var e protocol.PresenceEvent
+		if err := env.Decode(&e); err != nil {
+			return err
+		}
+		if e.Presence.Online {
+			s.display.ShowMessage(e.Presence.UserID + " is online")
+		} else {
+			s.display.ShowMessage(e.Presence.UserID + " went offline")
+		}
+
+	case protocol.TypeTypingStart, protocol.TypeTypingStop:
+		var e protocol.Typing
+		if err := env.Decode(&e); err != nil {
+			return err
+		}
+		s.updateTyping(e.ChatID, e.UserID, env.Type == protocol.TypeTypingStart)
+	}
+	return nil
+}
+
+// showNewMessage prints a message that just arrived and marks it read if it
+// is in the current chat
+func (s *InteractiveSession) showNewMessage(msg domain.Message) {
+	text := msg.Text
+	if msg.ReplyTo != "" {
+		text = "↪ " + text
+	}
+	s.display.ShowIncomingMessage(msg.From, msg.ChatID, text)
+	if s.isCurrentChat(msg.ChatID) && msg.From != s.userID {
+		s.markRead(msg.ChatID, msg.ID)
+	}
+}
+
+// showMemberEvent prints a change to the membership of a chat
+func (s *InteractiveSession) showMemberEvent(t protocol.Type, event protocol.MemberEvent) {
+	var text string
+	switch t {
+	case protocol.TypeMemberAdded:
+		text = fmt.Sprintf("%s added %s", event.By, event.UserID)
+	case protocol.TypeMemberRemoved:
+		switch {
+		case event.UserID == s.userID && event.By != s.userID:
+			text = fmt.Sprintf("%s removed you, you will no longer receive its messages", event.By)
+		case event.Banned:
+			text = fmt.Sprintf("%s kicked %s", event.By, event.UserID)
+		case event.By == event.UserID:
+			text = fmt.Sprintf("%s left", event.UserID)
+		default:
+			text = fmt.Sprintf("%s removed %s", event.By, event.UserID)
+		}
+	case protocol.TypeMemberRoleChanged:
+		text = fmt.Sprintf("%s is now %s", event.UserID, event.Role)
+	default:
+		return
+	}
+	s.display.ShowMessage(fmt.Sprintf("[%s] %s", event.Chat.ID, text))
+}
+
+func (s *InteractiveSession) setCurrentChat(chatID string) {
+	s.chatMu.Lock()
+	s.currentChat = chatID
+	s.chatMu.Unlock()
+
+	s.typingMu.Lock()
+	s.typing = nil
+	s.typingMu.Unlock()
+	s.display.ShowStatus("")
+}
+
+func (s *InteractiveSession) isCurrentChat(chatID string) bool {
+	return chatID == s.getCurrentChat()
+}
+
+func (s *InteractiveSession) getCurrentChat() string {
+	s.chatMu.RLock()
+	defer s.chatMu.RUnlock()
+	return s.currentChat
+}
+
+// typingRefresh is how often we repeat typing_start while the user keeps
+// typing; it must stay below the server's app.TypingTimeout
+const typingRefresh = 3 * time.Second
+
+// noteTyping is called for every key pressed and tells the server when the
+// user is writing a message in the current chat
+func (s *InteractiveSession) noteTyping(line string, key rune) {
+	if s.currentChat == "" || strings.HasPrefix(line, "/") || (line == "" && key == '/') {
+		return
+	}
+	if time.Since(s.typingSent) < typingRefresh {
+		return
+	}
+	if err := s.send(protocol.TypeTypingStart, protocol.ChatRef{ChatID: s.currentChat}); err == nil {
+		s.typingSent = time.Now()
+	}
+}
+
+// stopTyping takes back a typing_start when the line is not sent as a message
+func (s *InteractiveSession) stopTyping() {
+	if s.typingSent.IsZero() || s.currentChat == "" {
+		return
+	}
+	s.send(protocol.TypeTypingStop, protocol.ChatRef{ChatID: s.currentChat})
+	s.typingSent = time.Time{}
+}
+
+// updateTyping tracks who else is typing in the current chat and shows it
+func (s *InteractiveSession) updateTyping(chatID, userID string, typing bool) {
+	if userID == s.userID || !s.isCurrentChat(chatID) {
+		return
+	}
+
+	s.typingMu.Lock()
+	s.typing = slices.DeleteFunc(s.typing, func(id string) bool { return id == userID })
+	if typing {
+		s.typing = append(s.typing, userID)
+	}
+	status := typingStatus(s.typing)
+	s.typingMu.Unlock()
+
+	s.display.ShowStatus(status)
+}
+
+func typingStatus(users []string) string {
+	switch len(users) {
+	case 0:
+		return ""
+	case 1:
+		return users[0] + " is typing…"
+	case 2:
+		return users[0] + " and " + users[1] + " are typing…"
+	default:
+		return fmt.Sprintf("%d people are typing…", len(users))
+	}
+}
+
+// send sends a request we need no answer to; the server still reports it
+// if it fails
+func (s *InteractiveSession) send(t protocol.Type, payload any) error {
+	return s.request("", t, payload)
+}
+
+// request sends a request that the server answers with an ack or an error
+// carrying requestID
+func (s *InteractiveSession) request(requestID string, t protocol.Type, payload any) error {
+	env := protocol.New(t, payload)
+	env.RequestID = requestID
+	return s.write(env)
+}
+
+func (s *InteractiveSession) write(env protocol.Envelope) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.conn.WriteJSON(env)
+}
+
+// sendChatMessage sends a message under a new client ID and keeps it pending
+// until the server acknowledges or echoes it. The client ID doubles as the
+// request ID.
+func (s *InteractiveSession) sendChatMessage(msg protocol.Send) {
+	msg.ClientID = uuid.NewString()
+	req := protocol.New(protocol.TypeSend, msg)
+	req.RequestID = msg.ClientID
+
+	s.pendingMu.Lock()
+	s.pending[msg.ClientID] = pendingMessage{req: req, sentAt: time.Now()}
+	s.pendingMu.Unlock()
+
+	if err := s.write(req); err != nil {
+		s.display.ShowError("Not connected, the message will be sent once we reconnect")
+	}
+}
+
+// confirmSent drops msg from the pending messages if it is one of ours
+func (s *InteractiveSession) confirmSent(msg domain.Message) {
+	if msg.From == s.userID {
+		s.forgetPending(msg.ClientID)
+	}
+}
+
+// forgetPending drops a pending message and reports whether there was one
+func (s *InteractiveSession) forgetPending(clientID string) bool {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+
+	_, ok := s.pending[clientID]
+	delete(s.pending, clientID)
+	return ok
+}
+
+// resendWindow is how long a pending message is sent again after reconnects;
+// it must stay well below domain.ClientIDWindow, after which the server
+// would store a resent message twice
+const resendWindow = 10 * time.Minute
+
+// resendPending sends the pending messages again, in the order they were
+// first sent
+func (s *InteractiveSession) resendPending() {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+
+	for id, p := range s.pending {
+		if time.Since(p.sentAt) > resendWindow {
+			delete(s.pending, id)
+		}
+	}
+	resend := slices.SortedFunc(maps.Values(s.pending), func(a, b pendingMessage) int {
+		return a.sentAt.Compare(b.sentAt)
+	})
+	for _, p := range resend {
+		if err := s.write(p.req); err != nil {
+			return
+		}
+	}
+}
+
+// markRead tells the server we have seen chatID up to messageID
+func (s *InteractiveSession) markRead(chatID, messageID string) {
+	if err := s.send(protocol.TypeRead, protocol.Read{ChatID: chatID, MessageID: messageID}); err != nil {
+		s.display.ShowError("Failed to mark chat as read")
+	}
+}
+
+// catchUp shows the messages of a chat between afterSeq and seq, which did not
+// reach us live. A gap in Seqs does not always mean messages were missed, a
+// failed store leaves one too, so the server is asked before warning.
+func (s *InteractiveSession) catchUp(chatID string, afterSeq, seq int64) {
+	page := domain.MessagePage{Limit: int(min(seq-afterSeq-1, domain.MaxMessagePageSize))}
+	query := url.Values{}
+	query.Set("chat_id", chatID)
+	query.Set("after_seq", strconv.FormatInt(afterSeq, 10))
+	query.Set("limit", strconv.Itoa(page.Limit))
+
+	var msgs []domain.Message
+	resp, err := httpClient.Get(fmt.Sprintf("http://%s/messages?%s", s.serverAddr, query.Encode()))
+	if err == nil {
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			err = errors.New(resp.Status)
+		} else {
+			err = json.NewDecoder(resp.Body).Decode(&msgs)
+		}
+	}
+	if err != nil {
+		s.display.ShowError(fmt.Sprintf("Messages may be missing in %s, use /history to catch up", chatID))
+		return
+	}
+
+	for _, msg := range msgs {
+		if msg.Seq >= seq {
+			break
+		}
+		s.showNewMessage(msg)
+	}
+	if len(msgs) == page.Size() && msgs[len(msgs)-1].Seq < seq-1 {
+		s.display.ShowError(fmt.Sprintf("Missed more messages in %s, use /history to catch up", chatID))
+	}
+}
+
+// trackSeq records seq as seen in chatID and returns how many messages were
+// skipped since the previous one we saw. fresh is false if we had seen seq
+// already.
+func (s *InteractiveSession) trackSeq(chatID string, seq int64) (missed int64, fresh bool) {
+	s.seqMu.Lock()
+	defer s.seqMu.Unlock()
+
+	last, known := s.lastSeq[chatID]
+	if seq <= last {
+		return 0, false
+	}
+	s.lastSeq[chatID] = seq
+	if !known {
+		return 0, true
+	}
+	return seq - last - 1, true
+}
+
 func (s *InteractiveSession) commandLoop() {
 	s.display.ShowPrompt()
-	for s.scanner.Scan() {
-		line := strings.TrimSpace(s.scanner.Text())
+	for {
+		line, ok := s.readLine()
+		if !ok {
+			return
+		}
+		line = strings.TrimSpace(line)
 		if line == "" {
+			s.stopTyping()
 			s.display.ShowPrompt()
 			continue
 		}
 
 		if strings.HasPrefix(line, "/") {
+			s.stopTyping()
 			s.handleCommand(line)
 		} else {
+			// the server ends the typing indicator when the message arrives
+			s.typingSent = time.Time{}
 			s.handlePlainText(line)
 		}
 
@@ -119,6 +693,27 @@ func (s *InteractiveSession) commandLoop() {
 	}
 }
 
+// readLine reads from the display if it owns the input line and from stdin
+// otherwise
+func (s *InteractiveSession) readLine() (string, bool) {
+	if r, ok := s.display.(interface{ ReadLine() (string, error) }); ok {
+		line, err := r.ReadLine()
+		return line, err == nil
+	}
+	if !s.scanner.Scan() {
+		return "", false
+	}
+	return s.scanner.Text(), true
+}
+
+// exit leaves the terminal as we found it before quitting
+func (s *InteractiveSession) exit() {
+	if c, ok := s.display.(io.Closer); ok {
+		c.Close()
+	}
+	os.Exit(0)
+}
+
 func (s *InteractiveSession) handleCommand(line string) {
 	parts := strings.Fields(line)
 	if len(parts) == 0 {
@@ -132,19 +727,39 @@ func (s *InteractiveSession) handleCommand(line string) {
 	case "/help":
 		s.showHelp()
 	case "/quit":
-		os.Exit(0)
+		s.exit()
 	case "/chat":
 		s.handleChatCommand(args)
-	case "/user":
-		s.handleUserCommand(args)
 	case "/msg":
 		s.handleMsgCommand(args)
 	case "/history":
 		s.handleHistoryCommand(args)
+	case "/search":
+		s.handleSearchCommand(args)
+	case "/edit":
+		s.handleEditCommand(args)
+	case "/delete":
+		s.handleDeleteCommand(args)
+	case "/reply":
+		s.handleReplyCommand(args)
+	case "/thread":
+		s.handleThreadCommand(args)
+	case "/react":
+		s.handleReactCommand(args, false)
+	case "/unreact":
+		s.handleReactCommand(args, true)
 	case "/members":
 		s.handleMembersCommand()
+	case "/dm":
+		s.handleDmCommand(args)
+	case "/invite":
+		s.handleInviteCommand(args)
+	case "/kick":
+		s.handleKickCommand(args)
 	case "/leave":
 		s.handleLeaveCommand()
+	case "/devices":
+		s.handleDevicesCommand()
 	default:
 		s.display.ShowError(fmt.Sprintf("Unknown command: %s", cmd))
 	}
@@ -156,32 +771,39 @@ func (s *InteractiveSession) handlePlainText(text string) {
 		return
 	}
 
-	msg := WSMessage{
-		Type:   "message",
+	s.sendChatMessage(protocol.Send{
 		ChatID: s.currentChat,
 		Text:   text,
-	}
-
-	if err := s.conn.WriteJSON(msg); err != nil {
-		s.display.ShowError("Failed to send message")
-	}
+	})
 }
 
 func (s *InteractiveSession) showHelp() {
 	help := `Available commands:
 /help                           - Show this help
 /quit                          - Exit interactive mode
-/user create <id> <name>       - Create new user
 /chat list                     - List your chats
 /chat create <id> <u1>,<u2>    - Create new chat
 /chat use <chat_id>            - Enter chat mode
+/dm <user_id>                  - Enter your direct chat with a user
 /msg send <chat_id> <text>     - Send message to chat
 /msg list <chat_id> [limit]    - List messages from chat
+/devices                       - List where you are connected from
+/search <query>                - Search your chats; filters: from:<user>
+                                 in:<chat> before:YYYY-MM-DD after:YYYY-MM-DD
 
 In chat mode (after /chat use):
 <text>                         - Send message to current chat
 /history [limit]               - Show message history
-/members                       - Show chat members
+/history more [limit]          - Show older messages
+/edit <n> <text>               - Edit your message #n of the last listing
+/delete <n>                    - Delete your message #n of the last listing
+/reply <n> <text>              - Reply to message #n of the last listing
+/thread <n>                    - Show the thread of message #n
+/react <n> <emoji>             - React to message #n, or take the reaction back
+/unreact <n> <emoji>           - Remove your reaction from message #n
+/members                       - Show chat members and their roles
+/invite <user_id>              - Add a user to the chat (admins only)
+/kick <user_id>                - Remove and ban a user (admins only)
 /leave                         - Exit chat mode`
 
 	s.display.ShowMessage(help)
@@ -213,24 +835,6 @@ func (s *InteractiveSession) handleChatCommand(args []string) {
 	}
 }
 
-func (s *InteractiveSession) handleUserCommand(args []string) {
-	if len(args) == 0 {
-		s.display.ShowError("Usage: /user <create>")
-		return
-	}
-
-	switch args[0] {
-	case "create":
-		if len(args) < 3 {
-			s.display.ShowError("Usage: /user create <id> <name>")
-			return
-		}
-		s.createUser(args[1], strings.Join(args[2:], " "))
-	default:
-		s.display.ShowError("Unknown user command: " + args[0])
-	}
-}
-
 func (s *InteractiveSession) handleMsgCommand(args []string) {
 	if len(args) == 0 {
 		s.display.ShowError("Usage: /msg <send|list>")
@@ -266,13 +870,60 @@ func (s *InteractiveSession) handleHistoryCommand(args []string) {
 		s.display.ShowError("No active chat")
 		return
 	}
-	limit := 10
+
+	page := domain.MessagePage{Limit: 10}
+	if len(args) > 0 && args[0] == "more" {
+		if s.oldestShown == "" {
+			s.display.ShowMessage("No older messages")
+			return
+		}
+		page.Before = s.oldestShown
+		args = args[1:]
+	}
 	if len(args) > 0 {
 		if l, err := strconv.Atoi(args[0]); err == nil {
-			limit = l
+			page.Limit = l
 		}
 	}
-	s.listMessages(s.currentChat, limit)
+	s.showHistory(s.currentChat, page)
+}
+
+func (s *InteractiveSession) handleSearchCommand(args []string) {
+	if len(args) == 0 {
+		s.display.ShowError("Usage: /search <query>")
+		return
+	}
+
+	query := url.Values{}
+	query.Set("q", strings.Join(args, " "))
+
+	resp, err := httpClient.Get(fmt.Sprintf("http://%s/search?%s", s.serverAddr, query.Encode()))
+	if err != nil {
+		s.display.ShowError("Failed to search messages")
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		s.display.ShowError(strings.TrimSpace(string(body)))
+		return
+	}
+
+	var msgs []domain.Message
+	if err := json.NewDecoder(resp.Body).Decode(&msgs); err != nil {
+		s.display.ShowError("Failed to parse search results")
+		return
+	}
+
+	if len(msgs) == 0 {
+		s.display.ShowMessage("No messages found")
+		return
+	}
+	for _, msg := range msgs {
+		s.display.ShowMessage(fmt.Sprintf("[%s] (%s) %s: %s",
+			msg.CreatedAt.Format("2006-01-02 15:04"), msg.ChatID, msg.From, msg.Text))
+	}
 }
 
 func (s *InteractiveSession) handleMembersCommand() {
@@ -283,6 +934,104 @@ func (s *InteractiveSession) handleMembersCommand() {
 	s.showChatMembers(s.currentChat)
 }
 
+// handleDevicesCommand lists the user's live connections, this one included
+func (s *InteractiveSession) handleDevicesCommand() {
+	resp, err := httpClient.Get(fmt.Sprintf("http://%s/users/%s/connections", s.serverAddr, url.PathEscape(s.userID)))
+	if err != nil {
+		s.display.ShowError("Failed to fetch connections")
+		return
+	}
+	defer resp.Body.Close()
+
+	var connections []struct {
+		ID          string    `json:"id"`
+		ConnectedAt time.Time `json:"connected_at"`
+		RemoteAddr  string    `json:"remote_addr"`
+		Chats       []string  `json:"chats"`
+	}
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&connections) != nil {
+		s.display.ShowError("Failed to fetch connections")
+		return
+	}
+
+	s.display.ShowMessage("Connected from:")
+	for _, c := range connections {
+		line := fmt.Sprintf("  %s since %s", c.RemoteAddr, c.ConnectedAt.Local().Format("2006-01-02 15:04"))
+		if len(c.Chats) > 0 {
+			line += " in " + strings.Join(c.Chats, ", ")
+		}
+		s.writeMu.Lock()
+		current := c.ID == s.connectionID
+		s.writeMu.Unlock()
+		if current {
+			line += " (this terminal)"
+		}
+		s.display.ShowMessage(line)
+	}
+}
+
+func (s *InteractiveSession) handleDmCommand(args []string) {
+	if len(args) < 1 {
+		s.display.ShowError("Usage: /dm <user_id>")
+		return
+	}
+
+	body, _ := json.Marshal(map[string]string{"other_id": args[0]})
+	resp, err := httpClient.Post(fmt.Sprintf("http://%s/chats/direct", s.serverAddr), "application/json", bytes.NewReader(body))
+	if err != nil {
+		s.display.ShowError("Failed to open direct chat")
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		msg, _ := io.ReadAll(resp.Body)
+		s.display.ShowError(strings.TrimSpace(string(msg)))
+		return
+	}
+
+	var chat domain.Chat
+	if err := json.NewDecoder(resp.Body).Decode(&chat); err != nil {
+		s.display.ShowError("Failed to parse chat info")
+		return
+	}
+	s.useChat(chat.ID)
+}
+
+func (s *InteractiveSession) handleInviteCommand(args []string) {
+	if s.currentChat == "" {
+		s.display.ShowError("No active chat")
+		return
+	}
+	if len(args) < 1 {
+		s.display.ShowError("Usage: /invite <user_id>")
+		return
+	}
+
+	body, _ := json.Marshal(map[string]string{"member_id": args[0]})
+	endpoint := fmt.Sprintf("http://%s/chats/%s/members", s.serverAddr, url.PathEscape(s.currentChat))
+	if err := s.doRequest(http.MethodPost, endpoint, body); err != nil {
+		s.display.ShowError(err.Error())
+	}
+}
+
+func (s *InteractiveSession) handleKickCommand(args []string) {
+	if s.currentChat == "" {
+		s.display.ShowError("No active chat")
+		return
+	}
+	if len(args) < 1 {
+		s.display.ShowError("Usage: /kick <user_id>")
+		return
+	}
+
+	endpoint := fmt.Sprintf("http://%s/chats/%s/members/%s?ban=true", s.serverAddr,
+		url.PathEscape(s.currentChat), url.PathEscape(args[0]))
+	if err := s.doRequest(http.MethodDelete, endpoint, nil); err != nil {
+		s.display.ShowError(err.Error())
+	}
+}
+
 func (s *InteractiveSession) handleLeaveCommand() {
 	if s.currentChat == "" {
 		s.display.ShowError("No active chat")
@@ -290,28 +1039,25 @@ func (s *InteractiveSession) handleLeaveCommand() {
 	}
 
 	// Unsubscribe from current chat
-	unsubMsg := WSMessage{
-		Type:   "unsubscribe",
-		ChatID: s.currentChat,
-	}
-	if err := s.conn.WriteJSON(unsubMsg); err != nil {
+	if err := s.send(protocol.TypeUnsubscribe, protocol.ChatRef{ChatID: s.currentChat}); err != nil {
 		s.display.ShowError("Failed to unsubscribe from chat")
 	}
 
 	s.display.ShowMessage(fmt.Sprintf("Left chat: %s", s.currentChat))
-	s.currentChat = ""
+	s.setCurrentChat("")
+	s.oldestShown = ""
 }
 
 func (s *InteractiveSession) listChats() {
-	url := fmt.Sprintf("http://%s/chats?user_id=%s", s.serverAddr, s.userID)
-	resp, err := http.Get(url)
+	url := fmt.Sprintf("http://%s/chats", s.serverAddr)
+	resp, err := httpClient.Get(url)
 	if err != nil {
 		s.display.ShowError("Failed to fetch chats")
 		return
 	}
 	defer resp.Body.Close()
 
-	var chats []domain.Chat
+	var chats []domain.UserChat
 	if err := json.NewDecoder(resp.Body).Decode(&chats); err != nil {
 		s.display.ShowError("Failed to parse chats")
 		return
@@ -324,9 +1070,31 @@ func (s *InteractiveSession) listChats() {
 
 	s.display.ShowMessage("Your chats:")
 	for _, chat := range chats {
-		members := strings.Join(chat.Members, ", ")
-		s.display.ShowMessage(fmt.Sprintf("  %s: [%s]", chat.ID, members))
+		line := "  " + s.describeChat(chat.Chat)
+		if chat.UnreadCount > 0 {
+			line += fmt.Sprintf(" (%d unread)", chat.UnreadCount)
+		}
+		s.display.ShowMessage(line)
+	}
+}
+
+// describeChat renders a chat for listings: direct chats by the other
+// member, groups by name with their members
+func (s *InteractiveSession) describeChat(chat domain.Chat) string {
+	if chat.IsDirect() {
+		others := slices.DeleteFunc(slices.Clone(chat.Members), func(id string) bool { return id == s.userID })
+		return fmt.Sprintf("%s: direct chat with %s", chat.ID, strings.Join(others, ", "))
+	}
+
+	line := chat.ID
+	if chat.Name != "" {
+		line += " \"" + chat.Name + "\""
+	}
+	line += fmt.Sprintf(": [%s]", strings.Join(chat.Members, ", "))
+	if chat.Description != "" {
+		line += " - " + truncate(chat.Description, 40)
 	}
+	return line
 }
 
 func (s *InteractiveSession) createChat(chatID, membersStr string) {
@@ -343,96 +1111,388 @@ func (s *InteractiveSession) createChat(chatID, membersStr string) {
 }
 
 func (s *InteractiveSession) useChat(chatID string) {
+	// only members get in, and the server would refuse the subscription
+	if _, err := s.fetchChat(chatID); err != nil {
+		s.display.ShowError(err.Error())
+		return
+	}
+
 	// Unsubscribe from previous chat if any
 	if s.currentChat != "" {
-		unsubMsg := WSMessage{
-			Type:   "unsubscribe",
-			ChatID: s.currentChat,
-		}
-		s.conn.WriteJSON(unsubMsg)
+		s.send(protocol.TypeUnsubscribe, protocol.ChatRef{ChatID: s.currentChat})
 	}
 
-	s.currentChat = chatID
+	s.setCurrentChat(chatID)
+	s.oldestShown = ""
 	s.display.ShowMessage(fmt.Sprintf("Entered chat: %s", chatID))
 
 	// Subscribe to new chat
-	subMsg := WSMessage{
-		Type:   "subscribe",
-		ChatID: chatID,
-	}
-	if err := s.conn.WriteJSON(subMsg); err != nil {
+	if err := s.send(protocol.TypeSubscribe, protocol.ChatRef{ChatID: chatID}); err != nil {
 		s.display.ShowError("Failed to subscribe to chat")
 		return
 	}
 
-	s.listMessages(chatID, 5)
-}
-
-func (s *InteractiveSession) createUser(userID, name string) {
-	postJSON(fmt.Sprintf("http://%s", s.serverAddr), "/users", map[string]string{
-		"id":   userID,
-		"name": name,
-	})
-	s.display.ShowMessage(fmt.Sprintf("User %s created", userID))
+	s.showHistory(chatID, domain.MessagePage{Limit: 5})
 }
 
 func (s *InteractiveSession) sendMessage(chatID, text string) {
-	msg := WSMessage{
-		Type:   "message",
+	s.sendChatMessage(protocol.Send{
 		ChatID: chatID,
 		Text:   text,
-	}
+	})
+}
+
+func (s *InteractiveSession) listMessages(chatID string, limit int) {
+	s.fetchAndShowMessages(chatID, domain.MessagePage{Limit: limit})
+}
 
-	if err := s.conn.WriteJSON(msg); err != nil {
-		s.display.ShowError("Failed to send message")
+// showHistory prints a page of the current chat and remembers where it
+// starts so the next /history more continues from there
+func (s *InteractiveSession) showHistory(chatID string, page domain.MessagePage) {
+	msgs := s.fetchAndShowMessages(chatID, page)
+	if len(msgs) > 0 {
+		s.trackSeq(chatID, msgs[len(msgs)-1].Seq)
 	}
+	if len(msgs) < page.Size() {
+		// a short page means we reached the beginning of the chat
+		s.oldestShown = ""
+		return
+	}
+	s.oldestShown = msgs[0].ID
 }
 
-func (s *InteractiveSession) listMessages(chatID string, limit int) {
-	url := fmt.Sprintf("http://%s/messages?user_id=%s&chat_id=%s", s.serverAddr, s.userID, chatID)
-	resp, err := http.Get(url)
+func (s *InteractiveSession) fetchAndShowMessages(chatID string, page domain.MessagePage) []domain.Message {
+	query := url.Values{}
+	query.Set("chat_id", chatID)
+	query.Set("limit", strconv.Itoa(page.Limit))
+	if page.Before != "" {
+		query.Set("before", page.Before)
+	}
+
+	resp, err := httpClient.Get(fmt.Sprintf("http://%s/messages?%s", s.serverAddr, query.Encode()))
 	if err != nil {
 		s.display.ShowError("Failed to fetch messages")
-		return
+		return nil
 	}
 	defer resp.Body.Close()
 
 	var msgs []domain.Message
 	if err := json.NewDecoder(resp.Body).Decode(&msgs); err != nil {
 		s.display.ShowError("Failed to parse messages")
-		return
+		return nil
 	}
 
 	if len(msgs) == 0 {
 		s.display.ShowMessage("No messages found")
+		return nil
+	}
+
+	seenBy := s.seenBy(chatID, msgs)
+
+	s.shown = msgs
+	for i, msg := range msgs {
+		s.display.ShowMessage(fmt.Sprintf("#%d %s", i+1, formatMessage(msg)))
+		if reactions := formatReactions(msg); reactions != "" {
+			s.display.ShowMessage("    " + reactions)
+		}
+		if users := seenBy[msg.ID]; len(users) > 0 {
+			s.display.ShowMessage("    seen by " + strings.Join(users, ", "))
+		}
+	}
+
+	if page.Before == "" {
+		body, _ := json.Marshal(map[string]string{"message_id": msgs[len(msgs)-1].ID})
+		endpoint := fmt.Sprintf("http://%s/chats/%s/read", s.serverAddr, url.PathEscape(chatID))
+		if err := s.doRequest(http.MethodPost, endpoint, body); err != nil {
+			s.display.ShowError(err.Error())
+		}
+	}
+	return msgs
+}
+
+// seenBy places each other member's read position under the newest of msgs
+// they have read that they did not write themselves
+func (s *InteractiveSession) seenBy(chatID string, msgs []domain.Message) map[string][]string {
+	resp, err := httpClient.Get(fmt.Sprintf("http://%s/chats/%s/read", s.serverAddr, url.PathEscape(chatID)))
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+
+	var receipts []domain.ReadReceipt
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&receipts) != nil {
+		return nil
+	}
+
+	seenBy := make(map[string][]string)
+	for _, r := range receipts {
+		if r.UserID == s.userID {
+			continue
+		}
+		for i := len(msgs) - 1; i >= 0; i-- {
+			if msgs[i].Seq <= r.Seq && msgs[i].From != r.UserID {
+				seenBy[msgs[i].ID] = append(seenBy[msgs[i].ID], r.UserID)
+				break
+			}
+		}
+	}
+	return seenBy
+}
+
+// formatReactions renders the reaction counts of a message, e.g. "👍 2  🎉 1"
+func formatReactions(msg domain.Message) string {
+	var parts []string
+	for _, rc := range msg.ReactionCounts() {
+		parts = append(parts, fmt.Sprintf("%s %d", rc.Emoji, rc.Count))
+	}
+	return strings.Join(parts, "  ")
+}
+
+func truncate(text string, n int) string {
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	return string(runes[:n]) + "…"
+}
+
+func formatMessage(msg domain.Message) string {
+	text := msg.Text
+	switch {
+	case msg.Deleted():
+		text = "(message deleted)"
+	case msg.EditedAt != nil:
+		text += " (edited)"
+	}
+	if msg.ReplyTo != "" {
+		text = "↪ " + text
+	}
+	if msg.ReplyCount > 0 {
+		text += fmt.Sprintf(" [%d %s]", msg.ReplyCount, plural(msg.ReplyCount, "reply", "replies"))
+	}
+	return fmt.Sprintf("[%s] %s: %s", msg.CreatedAt.Format("15:04:05"), msg.From, text)
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
+
+// shownMessage resolves a 1-based index into the last listing
+func (s *InteractiveSession) shownMessage(index string) (domain.Message, bool) {
+	n, err := strconv.Atoi(strings.TrimPrefix(index, "#"))
+	if err != nil || n < 1 || n > len(s.shown) {
+		s.display.ShowError(fmt.Sprintf("No message #%s in the last listing, use /history first", index))
+		return domain.Message{}, false
+	}
+	return s.shown[n-1], true
+}
+
+func (s *InteractiveSession) handleEditCommand(args []string) {
+	if len(args) < 2 {
+		s.display.ShowError("Usage: /edit <n> <text>")
+		return
+	}
+	msg, ok := s.shownMessage(args[0])
+	if !ok {
 		return
 	}
 
-	start := 0
-	if len(msgs) > limit {
-		start = len(msgs) - limit
+	body, _ := json.Marshal(map[string]string{"text": strings.Join(args[1:], " ")})
+	endpoint := fmt.Sprintf("http://%s/messages/%s", s.serverAddr, msg.ID)
+	if err := s.doRequest(http.MethodPatch, endpoint, body); err != nil {
+		s.display.ShowError(err.Error())
+		return
 	}
+	s.display.ShowMessage("Message edited")
+}
 
-	for _, msg := range msgs[start:] {
-		s.display.ShowMessage(fmt.Sprintf("[%s] %s: %s", 
-			msg.CreatedAt.Format("15:04:05"), msg.From, msg.Text))
+func (s *InteractiveSession) handleDeleteCommand(args []string) {
+	if len(args) < 1 {
+		s.display.ShowError("Usage: /delete <n>")
+		return
+	}
+	msg, ok := s.shownMessage(args[0])
+	if !ok {
+		return
+	}
+
+	endpoint := fmt.Sprintf("http://%s/messages/%s", s.serverAddr, msg.ID)
+	if err := s.doRequest(http.MethodDelete, endpoint, nil); err != nil {
+		s.display.ShowError(err.Error())
+		return
 	}
+	s.display.ShowMessage("Message deleted")
 }
 
-func (s *InteractiveSession) showChatMembers(chatID string) {
-	url := fmt.Sprintf("http://%s/chats/%s", s.serverAddr, chatID)
-	resp, err := http.Get(url)
+func (s *InteractiveSession) handleReplyCommand(args []string) {
+	if len(args) < 2 {
+		s.display.ShowError("Usage: /reply <n> <text>")
+		return
+	}
+	msg, ok := s.shownMessage(args[0])
+	if !ok {
+		return
+	}
+
+	s.sendChatMessage(protocol.Send{
+		Text:    strings.Join(args[1:], " "),
+		ReplyTo: msg.ID,
+	})
+}
+
+// handleThreadCommand prints a thread; its messages become the listing that
+// /reply and friends refer to, so replying inside a thread is /reply <n>
+func (s *InteractiveSession) handleThreadCommand(args []string) {
+	if len(args) < 1 {
+		s.display.ShowError("Usage: /thread <n>")
+		return
+	}
+	msg, ok := s.shownMessage(args[0])
+	if !ok {
+		return
+	}
+
+	endpoint := fmt.Sprintf("http://%s/messages/%s/thread", s.serverAddr, msg.ID)
+	resp, err := httpClient.Get(endpoint)
 	if err != nil {
-		s.display.ShowError("Failed to fetch chat info")
+		s.display.ShowError("Failed to fetch thread")
 		return
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		s.display.ShowError(strings.TrimSpace(string(body)))
+		return
+	}
+
+	var thread []domain.Message
+	if err := json.NewDecoder(resp.Body).Decode(&thread); err != nil {
+		s.display.ShowError("Failed to parse thread")
+		return
+	}
+
+	s.shown = thread
+	for i, m := range thread {
+		indent := ""
+		if i > 0 {
+			indent = "    "
+		}
+		s.display.ShowMessage(fmt.Sprintf("%s#%d %s", indent, i+1, formatMessage(m)))
+		if reactions := formatReactions(m); reactions != "" {
+			s.display.ShowMessage(indent + "    " + reactions)
+		}
+	}
+}
+
+func (s *InteractiveSession) handleReactCommand(args []string, remove bool) {
+	if len(args) < 2 {
+		if remove {
+			s.display.ShowError("Usage: /unreact <n> <emoji>")
+		} else {
+			s.display.ShowError("Usage: /react <n> <emoji>")
+		}
+		return
+	}
+	msg, ok := s.shownMessage(args[0])
+	if !ok {
+		return
+	}
+	emoji := args[1]
+
+	if remove {
+		endpoint := fmt.Sprintf("http://%s/messages/%s/reactions/%s",
+			s.serverAddr, msg.ID, url.PathEscape(emoji))
+		if err := s.doRequest(http.MethodDelete, endpoint, nil); err != nil {
+			s.display.ShowError(err.Error())
+		}
+		return
+	}
+
+	body, _ := json.Marshal(map[string]string{"emoji": emoji})
+	endpoint := fmt.Sprintf("http://%s/messages/%s/reactions", s.serverAddr, msg.ID)
+	if err := s.doRequest(http.MethodPost, endpoint, body); err != nil {
+		s.display.ShowError(err.Error())
+	}
+}
+
+// doRequest sends a request and turns a non-2xx reply into an error
+// carrying the server's message
+func (s *InteractiveSession) doRequest(method, endpoint string, body []byte) error {
+	req, err := http.NewRequest(method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(resp.Body)
+		return errors.New(strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// fetchChat returns the chat if we are one of its members
+func (s *InteractiveSession) fetchChat(chatID string) (domain.Chat, error) {
+	resp, err := httpClient.Get(fmt.Sprintf("http://%s/chats/%s", s.serverAddr, url.PathEscape(chatID)))
+	if err != nil {
+		return domain.Chat{}, errors.New("failed to fetch chat info")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return domain.Chat{}, errors.New(strings.TrimSpace(string(msg)))
+	}
+
 	var chat domain.Chat
 	if err := json.NewDecoder(resp.Body).Decode(&chat); err != nil {
-		s.display.ShowError("Failed to parse chat info")
+		return domain.Chat{}, errors.New("failed to parse chat info")
+	}
+	return chat, nil
+}
+
+func (s *InteractiveSession) showChatMembers(chatID string) {
+	chat, err := s.fetchChat(chatID)
+	if err != nil {
+		s.display.ShowError(err.Error())
 		return
 	}
 
-	s.display.ShowMessage(fmt.Sprintf("Members of %s: %s", chatID, strings.Join(chat.Members, ", ")))
+	members := make([]string, len(chat.Members))
+	for i, member := range chat.Members {
+		members[i] = "○ " + member
+		if s.isOnline(member) {
+			members[i] = "● " + member
+		}
+		if role := chat.RoleOf(member); role != domain.RoleMember {
+			members[i] += " (" + string(role) + ")"
+		}
+	}
+	s.display.ShowMessage(fmt.Sprintf("Members of %s (● online): %s", chatID, strings.Join(members, ", ")))
+}
+
+func (s *InteractiveSession) isOnline(userID string) bool {
+	resp, err := httpClient.Get(fmt.Sprintf("http://%s/users/%s/presence", s.serverAddr, url.PathEscape(userID)))
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+
+	var presence domain.Presence
+	if err := json.NewDecoder(resp.Body).Decode(&presence); err != nil {
+		return false
+	}
+	return presence.Online
 }
@@ -4,34 +4,45 @@ import (
 	"bytes"
 	"cligram/internal/domain"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/google/uuid"
 )
 
+// sendAttempts is how many times a message is posted before giving up
+const sendAttempts = 3
+
 func MsgCmd(args []string) {
 	if len(args) < 1 {
-		fmt.Println("Usage: cligram msg send <from> <chat> <text> | msg list <user> <chat>")
+		fmt.Println("Usage: cligram msg send <chat> <text> | msg list <chat> [-limit N] [-before ID | -after ID] | msg search <query>")
+		return
+	}
+	if !requireLogin() {
 		return
 	}
 
 	switch args[0] {
 	case "send":
-		if len(args) < 4 {
-			fmt.Println("Usage: cligram msg send <from> <chat> <text>")
+		if len(args) < 3 {
+			fmt.Println("Usage: cligram msg send <chat> <text>")
 			return
 		}
-		from := args[1]
-		chatID := args[2]
-		text := strings.Join(args[3:], " ")
+		chatID := args[1]
+		text := strings.Join(args[2:], " ")
 
 		reqBody, _ := json.Marshal(map[string]string{
-			"from":    from,
-			"chat_id": chatID,
-			"text":    text,
+			"chat_id":   chatID,
+			"text":      text,
+			"client_id": uuid.NewString(),
 		})
 
-		resp, err := http.Post(serverURL+"/messages", "application/json", bytes.NewBuffer(reqBody))
+		resp, err := postMessage(reqBody)
 		if err != nil {
 			fmt.Println("Request error:", err)
 			return
@@ -43,18 +54,37 @@ func MsgCmd(args []string) {
 			return
 		}
 
-		fmt.Println("Message sent")
+		var msg domain.Message
+		if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+			fmt.Println("Message sent, but the reply could not be read:", err)
+			return
+		}
+		fmt.Printf("Message sent as #%d in %s (id %s)\n", msg.Seq, msg.ChatID, msg.ID)
 
 	case "list":
-		if len(args) != 3 {
-			fmt.Println("Usage: cligram msg list <user> <chat>")
+		if len(args) < 2 {
+			fmt.Println("Usage: cligram msg list <chat> [-limit N] [-before ID | -after ID]")
 			return
 		}
-		user := args[1]
-		chatID := args[2]
+		chatID := args[1]
 
-		url := fmt.Sprintf("%s/messages?user_id=%s&chat_id=%s", serverURL, user, chatID)
-		resp, err := http.Get(url)
+		fs := flag.NewFlagSet("list", flag.ExitOnError)
+		limit := fs.Int("limit", 20, "number of messages to show")
+		before := fs.String("before", "", "show messages before this message ID")
+		after := fs.String("after", "", "show messages after this message ID")
+		fs.Parse(args[2:])
+
+		query := url.Values{}
+		query.Set("chat_id", chatID)
+		query.Set("limit", strconv.Itoa(*limit))
+		if *before != "" {
+			query.Set("before", *before)
+		}
+		if *after != "" {
+			query.Set("after", *after)
+		}
+
+		resp, err := httpClient.Get(serverURL + "/messages?" + query.Encode())
 		if err != nil {
 			fmt.Println("Request error:", err)
 			return
@@ -76,7 +106,67 @@ func MsgCmd(args []string) {
 			fmt.Printf("[%s] %s: %s\n", m.CreatedAt.Format("15:04:05"), m.From, m.Text)
 		}
 
+		if len(msgs) == (domain.MessagePage{Limit: *limit}).Size() && *after == "" {
+			fmt.Printf("Older messages: cligram msg list %s -limit %d -before %s\n", chatID, *limit, msgs[0].ID)
+		}
+
+	case "search":
+		if len(args) < 2 {
+			fmt.Println("Usage: cligram msg search <query>")
+			fmt.Println("Query filters: from:<user> in:<chat> before:YYYY-MM-DD after:YYYY-MM-DD")
+			return
+		}
+
+		query := url.Values{}
+		query.Set("q", strings.Join(args[1:], " "))
+
+		resp, err := httpClient.Get(serverURL + "/search?" + query.Encode())
+		if err != nil {
+			fmt.Println("Request error:", err)
+			return
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			fmt.Println("Error:", resp.Status)
+			return
+		}
+
+		var msgs []domain.Message
+		if err := json.NewDecoder(resp.Body).Decode(&msgs); err != nil {
+			fmt.Println("Decode error:", err)
+			return
+		}
+		if len(msgs) == 0 {
+			fmt.Println("No messages found")
+			return
+		}
+		for _, m := range msgs {
+			fmt.Printf("[%s] (%s) %s: %s\n", m.CreatedAt.Format("2006-01-02 15:04"), m.ChatID, m.From, m.Text)
+		}
+
 	default:
 		fmt.Println("Unknown msg command:", args[0])
 	}
 }
+
+// postMessage posts a message, trying again after network and server errors.
+// The client ID in body keeps the server from storing it twice when an
+// earlier attempt got through after all.
+func postMessage(body []byte) (*http.Response, error) {
+	var resp *http.Response
+	var err error
+	for attempt := range sendAttempts {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+		resp, err = httpClient.Post(serverURL+"/messages", "application/json", bytes.NewReader(body))
+		if err == nil && resp.StatusCode < 500 {
+			return resp, nil
+		}
+		if err == nil && attempt < sendAttempts-1 {
+			resp.Body.Close()
+		}
+	}
+	return resp, err
+}
@@ -0,0 +1,105 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"unicode"
+
+	"golang.org/x/term"
+)
+
+const keyCtrlC = 3
+
+// TerminalDisplay implements DisplayManager on a terminal in raw mode. It owns
+// the input line, so whatever arrives while the user is typing is printed
+// above the line being edited instead of through it, and a status such as a
+// typing indicator is shown in front of the prompt.
+type TerminalDisplay struct {
+	term     *term.Terminal
+	fd       int
+	oldState *term.State
+
+	mu     sync.Mutex
+	status string
+}
+
+// NewTerminalDisplay switches the terminal fd refers to into raw mode; Close
+// switches it back
+func NewTerminalDisplay(fd int) (*TerminalDisplay, error) {
+	oldState, err := term.MakeRaw(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	screen := struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}
+
+	d := &TerminalDisplay{
+		term:     term.NewTerminal(screen, "> "),
+		fd:       fd,
+		oldState: oldState,
+	}
+	d.OnKey(nil)
+	return d, nil
+}
+
+func (d *TerminalDisplay) Close() error {
+	return term.Restore(d.fd, d.oldState)
+}
+
+func (d *TerminalDisplay) ShowMessage(msg string) {
+	fmt.Fprintln(d.term, msg)
+}
+
+func (d *TerminalDisplay) ShowError(err string) {
+	fmt.Fprintf(d.term, "Error: %s\n", err)
+}
+
+// ShowPrompt does nothing: the prompt is redrawn whenever a line is read
+func (d *TerminalDisplay) ShowPrompt() {}
+
+func (d *TerminalDisplay) ShowIncomingMessage(from, chatID, text string) {
+	fmt.Fprintf(d.term, "[%s] %s: %s\n", chatID, from, text)
+}
+
+func (d *TerminalDisplay) ShowStatus(status string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if status == d.status {
+		return
+	}
+	d.status = status
+
+	prompt := "> "
+	if status != "" {
+		prompt = "(" + status + ") > "
+	}
+	d.term.SetPrompt(prompt)
+	d.term.Write(nil) // repaints the prompt and the line being edited
+}
+
+// ReadLine reads the next line the user enters; it returns io.EOF on Ctrl-D
+func (d *TerminalDisplay) ReadLine() (string, error) {
+	return d.term.ReadLine()
+}
+
+// OnKey calls f with the line being edited, before the key is added, for
+// every printable key the user presses. Ctrl-C quits, as it would in
+// cooked mode.
+func (d *TerminalDisplay) OnKey(f func(line string, key rune)) {
+	d.term.AutoCompleteCallback = func(line string, pos int, key rune) (string, int, bool) {
+		if key == keyCtrlC {
+			d.Close()
+			os.Exit(0)
+		}
+		if f != nil && unicode.IsPrint(key) {
+			f(line, key)
+		}
+		return "", 0, false
+	}
+}
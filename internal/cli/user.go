@@ -23,7 +23,13 @@ func UserCmd(args []string) {
 			return
 		}
 
-		postJSON(serverURL, "/users", map[string]string{"id": *id, "name": *name})
+		password, err := readPassword("Password: ")
+		if err != nil {
+			fmt.Println("Failed to read password:", err)
+			return
+		}
+
+		postJSON(serverURL, "/users", map[string]string{"id": *id, "name": *name, "password": password})
 
 	default:
 		fmt.Println("Unknown user subcommand:", args[0])
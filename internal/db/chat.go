@@ -4,11 +4,9 @@ import (
 	"cligram/internal/domain"
 	"context"
 	"fmt"
-	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type ChatRepo struct {
@@ -16,38 +14,28 @@ type ChatRepo struct {
 }
 
 func NewChatRepo(client *mongo.Client) *ChatRepo {
+	// indexes are created by migrations, see migrations.go
 	coll := client.Database("cligram-db").Collection(string(ChatsCollection))
-
-	// ensure unique index on "id"
-	indexModel := mongo.IndexModel{
-		Keys:    map[string]int{"id": 1},
-		Options: options.Index().SetUnique(true),
-	}
-	_, err := coll.Indexes().CreateOne(context.Background(), indexModel)
-	if err != nil {
-		panic("failed to create chat id index: " + err.Error())
-	}
-
 	return &ChatRepo{collection: coll}
 }
 
 // Create implements repository.ChatRepository
-func (r *ChatRepo) Create(c domain.Chat) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (r *ChatRepo) Create(ctx context.Context, c domain.Chat) error {
+	ctx, cancel := withTimeout(ctx)
 	defer cancel()
 
 	_, err := r.collection.InsertOne(ctx, c)
 	if err != nil {
 		if mongo.IsDuplicateKeyError(err) {
-			return fmt.Errorf("chat with id %s already exists", c.ID)
+			return fmt.Errorf("%w: %s", domain.ErrChatExists, c.ID)
 		}
 		return err
 	}
 	return nil
 }
 
-func (r *ChatRepo) GetByID(id string) (domain.Chat, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (r *ChatRepo) GetByID(ctx context.Context, id string) (domain.Chat, error) {
+	ctx, cancel := withTimeout(ctx)
 	defer cancel()
 
 	var c domain.Chat
@@ -61,16 +49,57 @@ func (r *ChatRepo) GetByID(id string) (domain.Chat, error) {
 	return c, nil
 }
 
-func (r *ChatRepo) ListByUser(userID string) ([]domain.Chat, error) {
+func (r *ChatRepo) GetDirect(ctx context.Context, key string) (domain.Chat, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var c domain.Chat
+	err := r.collection.FindOne(ctx, bson.M{"direct_key": key}).Decode(&c)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return domain.Chat{}, domain.ErrChatNotFound
+		}
+		return domain.Chat{}, err
+	}
+	return c, nil
+}
+
+func (r *ChatRepo) Update(ctx context.Context, c domain.Chat) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	filter := bson.M{"id": c.ID, "version": c.Version}
+	if c.Version == 0 {
+		// the field is left out while it is zero
+		filter["version"] = bson.M{"$exists": false}
+	}
+	c.Version++
+	res, err := r.collection.ReplaceOne(ctx, filter, c)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		if _, err := r.GetByID(ctx, c.ID); err != nil {
+			return err
+		}
+		return domain.ErrChatChanged
+	}
+	return nil
+}
+
+func (r *ChatRepo) ListByUser(ctx context.Context, userID string) ([]domain.Chat, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	filter := bson.M{"members": userID}
-	cur, err := r.collection.Find(context.Background(), filter)
+	cur, err := r.collection.Find(ctx, filter)
 	if err != nil {
 		return nil, err
 	}
-	defer cur.Close(context.Background())
+	defer cur.Close(ctx)
 
 	var chats []domain.Chat
-	for cur.Next(context.Background()) {
+	for cur.Next(ctx) {
 		var chat domain.Chat
 		if err := cur.Decode(&chat); err != nil {
 			return nil, err
@@ -0,0 +1,46 @@
+package db
+
+import (
+	"cligram/internal/domain"
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Dump is a full copy of the cligram collections
+type Dump struct {
+	Users    []domain.User
+	Chats    []domain.Chat
+	Messages []domain.Message
+	Receipts []domain.ReadReceipt
+}
+
+// Export reads every document of the users, chats, messages and
+// read_receipts collections
+func Export(ctx context.Context, client *mongo.Client) (Dump, error) {
+	database := client.Database("cligram-db")
+
+	var dump Dump
+	if err := findAll(ctx, database.Collection(string(UsersCollection)), &dump.Users); err != nil {
+		return Dump{}, err
+	}
+	if err := findAll(ctx, database.Collection(string(ChatsCollection)), &dump.Chats); err != nil {
+		return Dump{}, err
+	}
+	if err := findAll(ctx, database.Collection(string(MessagesCollection)), &dump.Messages); err != nil {
+		return Dump{}, err
+	}
+	if err := findAll(ctx, database.Collection(string(ReadReceiptsCollection)), &dump.Receipts); err != nil {
+		return Dump{}, err
+	}
+	return dump, nil
+}
+
+func findAll(ctx context.Context, coll *mongo.Collection, results any) error {
+	cur, err := coll.Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	return cur.All(ctx, results)
+}
@@ -15,16 +15,39 @@ import (
 type CollectionName string
 
 const (
-	UsersCollection    CollectionName = "users"
-	MessagesCollection CollectionName = "messages"
-	ChatsCollection    CollectionName = "chats"
+	UsersCollection        CollectionName = "users"
+	MessagesCollection     CollectionName = "messages"
+	ChatsCollection        CollectionName = "chats"
+	CountersCollection     CollectionName = "counters"
+	MigrationsCollection   CollectionName = "migrations"
+	ReadReceiptsCollection CollectionName = "read_receipts"
+	TokensCollection       CollectionName = "tokens"
+	MessageKeysCollection  CollectionName = "message_keys"
+	EventsCollection       CollectionName = "events"
+	ConnectionsCollection  CollectionName = "connections"
 )
 
 var (
 	clientInstance *mongo.Client
 	mongoOnce      sync.Once
+
+	// queryTimeout bounds every repository call on top of the caller's context
+	queryTimeout = 5 * time.Second
 )
 
+// SetQueryTimeout changes how long a single repository call may take.
+// Zero or less leaves it to the caller's context alone.
+func SetQueryTimeout(d time.Duration) {
+	queryTimeout = d
+}
+
+func withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if queryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, queryTimeout)
+}
+
 func Connect() *mongo.Client {
 	mongoOnce.Do(func() {
 		_ = godotenv.Load(".env")
@@ -3,41 +3,300 @@ package db
 import (
 	"cligram/internal/domain"
 	"context"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type MessageRepo struct {
 	collection *mongo.Collection
+	counters   *mongo.Collection
+	// keys maps the client keys of recent messages to their IDs; the _id
+	// index makes every key unique and a TTL index on expires_at frees it
+	// after domain.ClientIDWindow
+	keys *mongo.Collection
 }
 
 func NewMessageRepo(client *mongo.Client) *MessageRepo {
-	coll := client.Database("cligram-db").Collection(string(MessagesCollection))
-	return &MessageRepo{collection: coll}
+	// indexes are created by migrations, see migrations.go
+	database := client.Database("cligram-db")
+	return &MessageRepo{
+		collection: database.Collection(string(MessagesCollection)),
+		counters:   database.Collection(string(CountersCollection)),
+		keys:       database.Collection(string(MessageKeysCollection)),
+	}
+}
+
+// clientKey is a document of the keys collection
+type clientKey struct {
+	Key       string    `bson:"_id"`
+	MessageID string    `bson:"message_id"`
+	ExpiresAt time.Time `bson:"expires_at"`
 }
 
 // Create implements repository.MessageRepository
-func (r *MessageRepo) Create(m domain.Message) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (r *MessageRepo) Create(ctx context.Context, m domain.Message) (domain.Message, error) {
+	ctx, cancel := withTimeout(ctx)
 	defer cancel()
 
-	_, err := r.collection.InsertOne(ctx, m)
-	if err != nil {
+	if m.ClientID != "" {
+		if sent, err := r.claimClientKey(ctx, m); err != nil {
+			return sent, err
+		}
+	}
+
+	seq, err := r.nextSeq(ctx, m.ChatID)
+	if err == nil {
+		m.Seq = seq
+		_, err = r.collection.InsertOne(ctx, m)
 		if mongo.IsDuplicateKeyError(err) {
-			return fmt.Errorf("message with id %s already exists", m.ID)
+			err = fmt.Errorf("message with id %s already exists", m.ID)
+		}
+	}
+	if err != nil {
+		if m.ClientID != "" {
+			// let a retry store the message, even if ctx is what ran out
+			cleanupCtx, cancel := withTimeout(context.WithoutCancel(ctx))
+			defer cancel()
+			r.keys.DeleteOne(cleanupCtx, bson.M{"_id": m.ClientKey(), "message_id": m.ID})
 		}
-		return err
+		return domain.Message{}, err
+	}
+	return m, nil
+}
+
+// CountReply implements repository.MessageRepository
+func (r *MessageRepo) CountReply(ctx context.Context, rootID string) (domain.Message, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var root domain.Message
+	err := r.collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": rootID},
+		bson.M{"$inc": bson.M{"reply_count": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&root)
+	if err == mongo.ErrNoDocuments {
+		return domain.Message{}, domain.ErrMessageNotFound
+	}
+	if err != nil {
+		return domain.Message{}, fmt.Errorf("failed to count reply: %w", err)
+	}
+	return root, nil
+}
+
+// claimClientKey reserves the client key of m for it. If another message
+// holds the key, that message is returned with domain.ErrDuplicateMessage.
+func (r *MessageRepo) claimClientKey(ctx context.Context, m domain.Message) (domain.Message, error) {
+	claim := clientKey{Key: m.ClientKey(), MessageID: m.ID, ExpiresAt: m.CreatedAt.Add(domain.ClientIDWindow)}
+	_, err := r.keys.InsertOne(ctx, claim)
+	if !mongo.IsDuplicateKeyError(err) {
+		return domain.Message{}, err
+	}
+
+	var held clientKey
+	if err := r.keys.FindOne(ctx, bson.M{"_id": claim.Key}).Decode(&held); err != nil {
+		return domain.Message{}, fmt.Errorf("failed to look up client id %s: %w", m.ClientID, err)
+	}
+	if held.ExpiresAt.Before(time.Now()) {
+		// expired, but the TTL monitor only runs every minute; take it over
+		// unless someone else just did
+		res, err := r.keys.ReplaceOne(ctx, held, claim)
+		if err != nil {
+			return domain.Message{}, err
+		}
+		if res.ModifiedCount == 1 {
+			return domain.Message{}, nil
+		}
+		return domain.Message{}, fmt.Errorf("message with client id %s is being stored", m.ClientID)
+	}
+
+	sent, err := r.GetByID(ctx, held.MessageID)
+	if errors.Is(err, domain.ErrMessageNotFound) {
+		return domain.Message{}, fmt.Errorf("message with client id %s is being stored", m.ClientID)
+	}
+	if err != nil {
+		return domain.Message{}, err
+	}
+	return sent, domain.ErrDuplicateMessage
+}
+
+// nextSeq atomically increments and returns the chat's message counter
+func (r *MessageRepo) nextSeq(ctx context.Context, chatID string) (int64, error) {
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+	err := r.counters.FindOneAndUpdate(ctx,
+		bson.M{"_id": chatID},
+		bson.M{"$inc": bson.M{"seq": 1}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	if err != nil {
+		return 0, fmt.Errorf("failed to allocate message seq: %w", err)
 	}
-	return nil
+	return counter.Seq, nil
 }
 
-func (r *MessageRepo) ListByChat(chatID string) ([]domain.Message, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (r *MessageRepo) GetByID(ctx context.Context, id string) (domain.Message, error) {
+	ctx, cancel := withTimeout(ctx)
 	defer cancel()
 
-	cursor, err := r.collection.Find(ctx, map[string]string{"chat_id": chatID})
+	var m domain.Message
+	err := r.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&m)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return domain.Message{}, domain.ErrMessageNotFound
+		}
+		return domain.Message{}, err
+	}
+	return m, nil
+}
+
+// Edit implements repository.MessageRepository. The old text is moved into
+// revisions by the same atomic update, so concurrent edits cannot lose one.
+func (r *MessageRepo) Edit(ctx context.Context, id string, text string, at time.Time) (domain.Message, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	// all fields of a $set stage are computed from the document as it was
+	// before the stage, so "$text" below is still the previous text
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.D{
+		{Key: "revisions", Value: bson.M{"$concatArrays": bson.A{
+			bson.M{"$ifNull": bson.A{"$revisions", bson.A{}}},
+			bson.A{bson.M{
+				"text":       "$text",
+				"created_at": bson.M{"$ifNull": bson.A{"$edited_at", "$created_at"}},
+			}},
+		}}},
+		{Key: "text", Value: bson.M{"$literal": text}},
+		{Key: "edited_at", Value: at},
+	}}}}
+
+	return r.updateLive(ctx, id, update)
+}
+
+// Delete implements repository.MessageRepository
+func (r *MessageRepo) Delete(ctx context.Context, id string, at time.Time) (domain.Message, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	update := bson.M{
+		"$set":   bson.M{"deleted_at": at, "text": ""},
+		"$unset": bson.M{"revisions": "", "reactions": ""},
+	}
+	return r.updateLive(ctx, id, update)
+}
+
+// AddReaction implements repository.MessageRepository. The filter only
+// matches a message without the reaction, so whether one matched tells
+// whether anything changed.
+func (r *MessageRepo) AddReaction(ctx context.Context, id string, reaction domain.Reaction) (domain.Message, bool, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	match := bson.M{"user_id": reaction.UserID, "emoji": reaction.Emoji}
+	return r.updateLiveIf(ctx, id,
+		bson.M{"reactions": bson.M{"$not": bson.M{"$elemMatch": match}}},
+		bson.M{"$push": bson.M{"reactions": reaction}},
+	)
+}
+
+// RemoveReaction implements repository.MessageRepository
+func (r *MessageRepo) RemoveReaction(ctx context.Context, id string, reaction domain.Reaction) (domain.Message, bool, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	match := bson.M{"user_id": reaction.UserID, "emoji": reaction.Emoji}
+	return r.updateLiveIf(ctx, id,
+		bson.M{"reactions": bson.M{"$elemMatch": match}},
+		bson.M{"$pull": bson.M{"reactions": match}},
+	)
+}
+
+// updateLive applies update to a message that has not been deleted and
+// returns the result
+func (r *MessageRepo) updateLive(ctx context.Context, id string, update any) (domain.Message, error) {
+	m, _, err := r.updateLiveIf(ctx, id, nil, update)
+	return m, err
+}
+
+// updateLiveIf applies update to a message that has not been deleted if it
+// also matches cond, and returns the message and whether it was updated
+func (r *MessageRepo) updateLiveIf(ctx context.Context, id string, cond bson.M, update any) (domain.Message, bool, error) {
+	filter := bson.M{"_id": id, "deleted_at": bson.M{"$exists": false}}
+	for k, v := range cond {
+		filter[k] = v
+	}
+	var m domain.Message
+	err := r.collection.FindOneAndUpdate(ctx,
+		filter,
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&m)
+	if err == mongo.ErrNoDocuments {
+		m, err := r.GetByID(ctx, id)
+		if err != nil {
+			return domain.Message{}, false, err
+		}
+		if m.Deleted() {
+			return domain.Message{}, false, domain.ErrMessageDeleted
+		}
+		// live, so cond did not match and there was nothing to do
+		return m, false, nil
+	}
+	if err != nil {
+		return domain.Message{}, false, err
+	}
+	return m, true, nil
+}
+
+// ListByChat returns one page of a chat's history, ordered by seq
+func (r *MessageRepo) ListByChat(ctx context.Context, chatID string, page domain.MessagePage) ([]domain.Message, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	filter := bson.M{"chat_id": chatID}
+	newestFirst := page.After == "" && page.AfterSeq == nil
+
+	cursorID := page.Before
+	if page.After != "" {
+		cursorID = page.After
+	}
+	if cursorID != "" {
+		var cursorMsg domain.Message
+		err := r.collection.FindOne(ctx, bson.M{"_id": cursorID, "chat_id": chatID}).Decode(&cursorMsg)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return nil, domain.ErrMessageNotFound
+			}
+			return nil, err
+		}
+
+		op := "$gt"
+		if newestFirst {
+			op = "$lt"
+		}
+		filter["seq"] = bson.M{op: cursorMsg.Seq}
+	} else if page.AfterSeq != nil {
+		filter["seq"] = bson.M{"$gt": *page.AfterSeq}
+	}
+
+	dir := 1
+	if newestFirst {
+		dir = -1
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "seq", Value: dir}})
+	if page.Limit > 0 {
+		opts.SetLimit(int64(page.Limit))
+	}
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -51,5 +310,95 @@ func (r *MessageRepo) ListByChat(chatID string) ([]domain.Message, error) {
 		}
 		messages = append(messages, m)
 	}
+
+	if newestFirst {
+		slices.Reverse(messages)
+	}
 	return messages, nil
 }
+
+func (r *MessageRepo) ListThread(ctx context.Context, rootID string) ([]domain.Message, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	filter := bson.M{"$or": bson.A{bson.M{"_id": rootID}, bson.M{"thread_root": rootID}}}
+	cursor, err := r.collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "seq", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []domain.Message
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+	if len(messages) == 0 {
+		return nil, domain.ErrMessageNotFound
+	}
+	return messages, nil
+}
+
+// Search uses the text index on messages.text. Each word is quoted as a
+// phrase, so a message matches if it contains every word as written, ignoring
+// case, and the index holds the stem of each. Unlike the memory backend, which
+// only matches whole words, "cat" then also finds "cats", while stop words
+// such as "the" find nothing.
+func (r *MessageRepo) Search(ctx context.Context, query domain.MessageSearch) ([]domain.Message, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	filter := bson.M{"deleted_at": bson.M{"$exists": false}}
+	if query.ChatIDs != nil {
+		filter["chat_id"] = bson.M{"$in": query.ChatIDs}
+	}
+	if query.From != "" {
+		filter["from"] = query.From
+	}
+
+	createdAt := bson.M{}
+	if !query.Before.IsZero() {
+		createdAt["$lt"] = query.Before
+	}
+	if !query.After.IsZero() {
+		createdAt["$gte"] = query.After
+	}
+	if len(createdAt) > 0 {
+		filter["created_at"] = createdAt
+	}
+
+	if words := strings.Fields(query.Text); len(words) > 0 {
+		// $text ORs plain words together; quoting each one makes all of them required
+		for i, w := range words {
+			words[i] = `"` + strings.ReplaceAll(w, `"`, "") + `"`
+		}
+		filter["$text"] = bson.M{"$search": strings.Join(words, " ")}
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}})
+	if query.Limit > 0 {
+		opts.SetLimit(int64(query.Limit))
+	}
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []domain.Message
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+func (r *MessageRepo) CountUnread(ctx context.Context, chatID, userID string, afterSeq int64) (int, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	n, err := r.collection.CountDocuments(ctx, bson.M{
+		"chat_id":    chatID,
+		"seq":        bson.M{"$gt": afterSeq},
+		"from":       bson.M{"$ne": userID},
+		"deleted_at": bson.M{"$exists": false},
+	})
+	return int(n), err
+}
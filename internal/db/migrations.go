@@ -0,0 +1,432 @@
+package db
+
+import (
+	"cligram/internal/domain"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Migration is one versioned change to the collections. Up applies it and
+// Down reverts it; both must be safe to run against a database that is only
+// partly migrated, since a failed run can leave it that way.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, db *mongo.Database) error
+	Down        func(ctx context.Context, db *mongo.Database) error
+}
+
+// MigrationState describes a known migration and whether it has been applied
+type MigrationState struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// migrationRecord is what gets stored in the migrations collection
+type migrationRecord struct {
+	Version     int       `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"applied_at"`
+}
+
+// migrations must stay sorted by Version; never edit one that has shipped,
+// add a new one instead
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "unique index on users.id",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			return createIndex(ctx, db.Collection(string(UsersCollection)), "id_1",
+				bson.D{{Key: "id", Value: 1}}, true)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return dropIndex(ctx, db.Collection(string(UsersCollection)), "id_1")
+		},
+	},
+	{
+		Version:     2,
+		Description: "unique index on chats.id, index on chats.members",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			coll := db.Collection(string(ChatsCollection))
+			if err := createIndex(ctx, coll, "id_1", bson.D{{Key: "id", Value: 1}}, true); err != nil {
+				return err
+			}
+			return createIndex(ctx, coll, "members_1", bson.D{{Key: "members", Value: 1}}, false)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			coll := db.Collection(string(ChatsCollection))
+			if err := dropIndex(ctx, coll, "members_1"); err != nil {
+				return err
+			}
+			return dropIndex(ctx, coll, "id_1")
+		},
+	},
+	{
+		Version:     3,
+		Description: "backfill messages.seq, unique index on messages (chat_id, seq)",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			if err := backfillMessageSeq(ctx, db); err != nil {
+				return err
+			}
+			return createIndex(ctx, db.Collection(string(MessagesCollection)), "chat_id_1_seq_1",
+				bson.D{{Key: "chat_id", Value: 1}, {Key: "seq", Value: 1}}, true)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			// the seq values stay; they are harmless without the index
+			return dropIndex(ctx, db.Collection(string(MessagesCollection)), "chat_id_1_seq_1")
+		},
+	},
+	{
+		Version:     4,
+		Description: "text index on messages.text for search",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			return createIndex(ctx, db.Collection(string(MessagesCollection)), "text_text",
+				bson.D{{Key: "text", Value: "text"}}, false)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return dropIndex(ctx, db.Collection(string(MessagesCollection)), "text_text")
+		},
+	},
+	{
+		Version:     5,
+		Description: "index on messages (thread_root, seq) for threads",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			return createIndex(ctx, db.Collection(string(MessagesCollection)), "thread_root_1_seq_1",
+				bson.D{{Key: "thread_root", Value: 1}, {Key: "seq", Value: 1}}, false)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return dropIndex(ctx, db.Collection(string(MessagesCollection)), "thread_root_1_seq_1")
+		},
+	},
+	{
+		Version:     6,
+		Description: "make the first member the owner of chats created before roles",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection(string(ChatsCollection)).UpdateMany(ctx,
+				bson.M{"owner": bson.M{"$exists": false}},
+				mongo.Pipeline{{{Key: "$set", Value: bson.M{
+					"owner": bson.M{"$arrayElemAt": bson.A{"$members", 0}},
+				}}}},
+			)
+			return err
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			// owners set since then are kept; without roles they are simply ignored
+			return nil
+		},
+	},
+	{
+		Version:     7,
+		Description: "chat types and a unique index on chats.direct_key",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			chats := db.Collection(string(ChatsCollection))
+			_, err := chats.UpdateMany(ctx,
+				bson.M{"type": bson.M{"$in": bson.A{nil, ""}}},
+				bson.M{"$set": bson.M{"type": domain.ChatTypeGroup}},
+			)
+			if err != nil {
+				return err
+			}
+			// sparse, because only direct chats have a key
+			_, err = chats.Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "direct_key", Value: 1}},
+				Options: options.Index().SetName("direct_key_1").SetUnique(true).SetSparse(true),
+			})
+			return err
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return dropIndex(ctx, db.Collection(string(ChatsCollection)), "direct_key_1")
+		},
+	},
+	{
+		Version:     8,
+		Description: "indexes on read_receipts (user_id, chat_id) and chat_id",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			receipts := db.Collection(string(ReadReceiptsCollection))
+			if err := createIndex(ctx, receipts, "user_id_1_chat_id_1",
+				bson.D{{Key: "user_id", Value: 1}, {Key: "chat_id", Value: 1}}, true); err != nil {
+				return err
+			}
+			return createIndex(ctx, receipts, "chat_id_1", bson.D{{Key: "chat_id", Value: 1}}, false)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			receipts := db.Collection(string(ReadReceiptsCollection))
+			if err := dropIndex(ctx, receipts, "chat_id_1"); err != nil {
+				return err
+			}
+			return dropIndex(ctx, receipts, "user_id_1_chat_id_1")
+		},
+	},
+	{
+		Version:     9,
+		Description: "unique index on tokens hash, expire tokens at expires_at",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			tokens := db.Collection(string(TokensCollection))
+			if err := createIndex(ctx, tokens, "hash_1", bson.D{{Key: "hash", Value: 1}}, true); err != nil {
+				return err
+			}
+			_, err := tokens.Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "expires_at", Value: 1}},
+				Options: options.Index().SetName("expires_at_1").SetExpireAfterSeconds(0),
+			})
+			return err
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			tokens := db.Collection(string(TokensCollection))
+			if err := dropIndex(ctx, tokens, "expires_at_1"); err != nil {
+				return err
+			}
+			return dropIndex(ctx, tokens, "hash_1")
+		},
+	},
+	{
+		Version:     10,
+		Description: "expire message client keys at expires_at",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection(string(MessageKeysCollection)).Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "expires_at", Value: 1}},
+				Options: options.Index().SetName("expires_at_1").SetExpireAfterSeconds(0),
+			})
+			return err
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return dropIndex(ctx, db.Collection(string(MessageKeysCollection)), "expires_at_1")
+		},
+	},
+	{
+		Version:     11,
+		Description: "expire broker events and connections at expires_at, index connections on user_id",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			for _, name := range []CollectionName{EventsCollection, ConnectionsCollection} {
+				_, err := db.Collection(string(name)).Indexes().CreateOne(ctx, mongo.IndexModel{
+					Keys:    bson.D{{Key: "expires_at", Value: 1}},
+					Options: options.Index().SetName("expires_at_1").SetExpireAfterSeconds(0),
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return createIndex(ctx, db.Collection(string(ConnectionsCollection)), "user_id_1", bson.D{{Key: "user_id", Value: 1}}, false)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			connections := db.Collection(string(ConnectionsCollection))
+			if err := dropIndex(ctx, connections, "user_id_1"); err != nil {
+				return err
+			}
+			if err := dropIndex(ctx, connections, "expires_at_1"); err != nil {
+				return err
+			}
+			return dropIndex(ctx, db.Collection(string(EventsCollection)), "expires_at_1")
+		},
+	},
+}
+
+// LatestVersion is the schema version the code expects
+var LatestVersion = migrations[len(migrations)-1].Version
+
+// Migrator applies and reverts migrations, recording them in the migrations collection
+type Migrator struct {
+	db      *mongo.Database
+	applied *mongo.Collection
+}
+
+func NewMigrator(client *mongo.Client) *Migrator {
+	database := client.Database("cligram-db")
+	return &Migrator{
+		db:      database,
+		applied: database.Collection(string(MigrationsCollection)),
+	}
+}
+
+// Status lists every known migration along with when it was applied
+func (m *Migrator) Status(ctx context.Context) ([]MigrationState, error) {
+	applied, err := m.appliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]MigrationState, 0, len(migrations))
+	for _, mig := range migrations {
+		state := MigrationState{Migration: mig}
+		if rec, ok := applied[mig.Version]; ok {
+			state.AppliedAt = &rec.AppliedAt
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// Pending returns the migrations that Up(ctx, LatestVersion) would apply
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	applied, err := m.appliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, mig := range migrations {
+		if _, ok := applied[mig.Version]; !ok {
+			pending = append(pending, mig)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies every unapplied migration up to and including version target
+func (m *Migrator) Up(ctx context.Context, target int) ([]Migration, error) {
+	applied, err := m.appliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, mig := range migrations {
+		if mig.Version > target {
+			break
+		}
+		if _, ok := applied[mig.Version]; ok {
+			continue
+		}
+
+		if err := mig.Up(ctx, m.db); err != nil {
+			return done, fmt.Errorf("migration %d (%s) failed: %w", mig.Version, mig.Description, err)
+		}
+		rec := migrationRecord{Version: mig.Version, Description: mig.Description, AppliedAt: time.Now()}
+		if _, err := m.applied.InsertOne(ctx, rec); err != nil {
+			return done, fmt.Errorf("failed to record migration %d: %w", mig.Version, err)
+		}
+		done = append(done, mig)
+	}
+	return done, nil
+}
+
+// Down reverts every applied migration with a version above target, newest first
+func (m *Migrator) Down(ctx context.Context, target int) ([]Migration, error) {
+	applied, err := m.appliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := len(migrations) - 1; i >= 0; i-- {
+		mig := migrations[i]
+		if mig.Version <= target {
+			break
+		}
+		if _, ok := applied[mig.Version]; !ok {
+			continue
+		}
+
+		if err := mig.Down(ctx, m.db); err != nil {
+			return done, fmt.Errorf("reverting migration %d (%s) failed: %w", mig.Version, mig.Description, err)
+		}
+		if _, err := m.applied.DeleteOne(ctx, bson.M{"_id": mig.Version}); err != nil {
+			return done, fmt.Errorf("failed to unrecord migration %d: %w", mig.Version, err)
+		}
+		done = append(done, mig)
+	}
+	return done, nil
+}
+
+func (m *Migrator) appliedVersions(ctx context.Context) (map[int]migrationRecord, error) {
+	cur, err := m.applied.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	var recs []migrationRecord
+	if err := cur.All(ctx, &recs); err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]migrationRecord, len(recs))
+	for _, rec := range recs {
+		applied[rec.Version] = rec
+	}
+	return applied, nil
+}
+
+func createIndex(ctx context.Context, coll *mongo.Collection, name string, keys bson.D, unique bool) error {
+	_, err := coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    keys,
+		Options: options.Index().SetName(name).SetUnique(unique),
+	})
+	return err
+}
+
+func dropIndex(ctx context.Context, coll *mongo.Collection, name string) error {
+	_, err := coll.Indexes().DropOne(ctx, name)
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Name == "IndexNotFound" {
+		return nil
+	}
+	return err
+}
+
+// backfillMessageSeq numbers messages stored before sequence numbers existed,
+// per chat in creation order after any already numbered ones, and moves the
+// chat counters past them
+func backfillMessageSeq(ctx context.Context, db *mongo.Database) error {
+	messages := db.Collection(string(MessagesCollection))
+	counters := db.Collection(string(CountersCollection))
+
+	missing := bson.M{"$or": bson.A{bson.M{"seq": bson.M{"$exists": false}}, bson.M{"seq": 0}}}
+	chatIDs, err := messages.Distinct(ctx, "chat_id", missing)
+	if err != nil {
+		return err
+	}
+
+	for _, chatID := range chatIDs {
+		var counter struct {
+			Seq int64 `bson:"seq"`
+		}
+		err := counters.FindOne(ctx, bson.M{"_id": chatID}).Decode(&counter)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return err
+		}
+
+		filter := bson.M{"chat_id": chatID, "$or": missing["$or"]}
+		cur, err := messages.Find(ctx, filter,
+			options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}).
+				SetProjection(bson.M{"_id": 1}))
+		if err != nil {
+			return err
+		}
+
+		seq := counter.Seq
+		for cur.Next(ctx) {
+			var doc struct {
+				ID string `bson:"_id"`
+			}
+			if err := cur.Decode(&doc); err != nil {
+				cur.Close(ctx)
+				return err
+			}
+			seq++
+			if _, err := messages.UpdateByID(ctx, doc.ID, bson.M{"$set": bson.M{"seq": seq}}); err != nil {
+				cur.Close(ctx)
+				return err
+			}
+		}
+		if err := cur.Err(); err != nil {
+			cur.Close(ctx)
+			return err
+		}
+		cur.Close(ctx)
+
+		_, err = counters.UpdateOne(ctx,
+			bson.M{"_id": chatID},
+			bson.M{"$max": bson.M{"seq": seq}},
+			options.Update().SetUpsert(true))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
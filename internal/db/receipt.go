@@ -0,0 +1,65 @@
+package db
+
+import (
+	"cligram/internal/domain"
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type ReadReceiptRepo struct {
+	collection *mongo.Collection
+}
+
+func NewReadReceiptRepo(client *mongo.Client) *ReadReceiptRepo {
+	// indexes are created by migrations, see migrations.go
+	coll := client.Database("cligram-db").Collection(string(ReadReceiptsCollection))
+	return &ReadReceiptRepo{collection: coll}
+}
+
+// Advance implements repository.ReadReceiptRepository. Only a receipt behind
+// the new position matches the filter; if the user has read further already
+// the upsert collides with the unique (user_id, chat_id) index instead.
+func (r *ReadReceiptRepo) Advance(ctx context.Context, receipt domain.ReadReceipt) (bool, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	_, err := r.collection.UpdateOne(ctx,
+		bson.M{"user_id": receipt.UserID, "chat_id": receipt.ChatID, "seq": bson.M{"$lt": receipt.Seq}},
+		bson.M{"$set": receipt},
+		options.Update().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *ReadReceiptRepo) ListByChat(ctx context.Context, chatID string) ([]domain.ReadReceipt, error) {
+	return r.find(ctx, bson.M{"chat_id": chatID})
+}
+
+func (r *ReadReceiptRepo) ListByUser(ctx context.Context, userID string) ([]domain.ReadReceipt, error) {
+	return r.find(ctx, bson.M{"user_id": userID})
+}
+
+func (r *ReadReceiptRepo) find(ctx context.Context, filter bson.M) ([]domain.ReadReceipt, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	cur, err := r.collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "chat_id", Value: 1}, {Key: "user_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	var receipts []domain.ReadReceipt
+	if err := cur.All(ctx, &receipts); err != nil {
+		return nil, err
+	}
+	return receipts, nil
+}
@@ -0,0 +1,57 @@
+package db
+
+import (
+	"cligram/internal/domain"
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type TokenRepo struct {
+	collection *mongo.Collection
+}
+
+func NewTokenRepo(client *mongo.Client) *TokenRepo {
+	// indexes are created by migrations, see migrations.go; MongoDB removes
+	// expired tokens on its own through the TTL index on expires_at
+	coll := client.Database("cligram-db").Collection(string(TokensCollection))
+	return &TokenRepo{collection: coll}
+}
+
+// Create implements repository.TokenRepository
+func (r *TokenRepo) Create(ctx context.Context, token domain.AuthToken) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	_, err := r.collection.InsertOne(ctx, token)
+	return err
+}
+
+func (r *TokenRepo) GetByHash(ctx context.Context, hash string) (domain.AuthToken, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var token domain.AuthToken
+	err := r.collection.FindOne(ctx, bson.M{"hash": hash}).Decode(&token)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return domain.AuthToken{}, domain.ErrTokenNotFound
+	}
+	return token, err
+}
+
+func (r *TokenRepo) Revoke(ctx context.Context, hash string, at time.Time) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	res, err := r.collection.UpdateOne(ctx, bson.M{"hash": hash}, bson.M{"$set": bson.M{"expires_at": at}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return domain.ErrTokenNotFound
+	}
+	return nil
+}
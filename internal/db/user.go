@@ -6,8 +6,8 @@ import (
 	"fmt"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type UserRepo struct {
@@ -15,38 +15,28 @@ type UserRepo struct {
 }
 
 func NewUserRepo(client *mongo.Client) *UserRepo {
+	// indexes are created by migrations, see migrations.go
 	coll := client.Database("cligram-db").Collection(string(UsersCollection))
-
-	// ensure unique index on "id"
-	indexModel := mongo.IndexModel{
-		Keys:    map[string]int{"id": 1}, // ascending
-		Options: options.Index().SetUnique(true),
-	}
-	_, err := coll.Indexes().CreateOne(context.Background(), indexModel)
-	if err != nil {
-		panic("failed to create user id index: " + err.Error())
-	}
-
 	return &UserRepo{collection: coll}
 }
 
 // Create implements repository.UserRepository
-func (r *UserRepo) Create(u domain.User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (r *UserRepo) Create(ctx context.Context, u domain.User) error {
+	ctx, cancel := withTimeout(ctx)
 	defer cancel()
 
 	_, err := r.collection.InsertOne(ctx, u)
 	if err != nil {
 		if mongo.IsDuplicateKeyError(err) {
-			return fmt.Errorf("user with id %s already exists", u.ID)
+			return fmt.Errorf("%w: %s", domain.ErrUserExists, u.ID)
 		}
 		return err
 	}
 	return nil
 }
 
-func (r *UserRepo) GetByID(id string) (domain.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (r *UserRepo) GetByID(ctx context.Context, id string) (domain.User, error) {
+	ctx, cancel := withTimeout(ctx)
 	defer cancel()
 
 	var u domain.User
@@ -59,3 +49,31 @@ func (r *UserRepo) GetByID(id string) (domain.User, error) {
 	}
 	return u, nil
 }
+
+func (r *UserRepo) SetLastSeen(ctx context.Context, id string, at time.Time) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	res, err := r.collection.UpdateOne(ctx, bson.M{"id": id}, bson.M{"$set": bson.M{"last_seen": at}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return domain.ErrUserNotFound
+	}
+	return nil
+}
+
+func (r *UserRepo) SetPasswordHash(ctx context.Context, id string, hash []byte) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	res, err := r.collection.UpdateOne(ctx, bson.M{"id": id}, bson.M{"$set": bson.M{"password_hash": hash}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return domain.ErrUserNotFound
+	}
+	return nil
+}
@@ -3,7 +3,36 @@ package domain
 import "errors"
 
 var (
-	ErrUserNotFound  = errors.New("user not found")
-	ErrChatNotFound  = errors.New("chat not found")
-	ErrUserNotInChat = errors.New("user is not a member of the chat")
+	ErrUserNotFound    = errors.New("user not found")
+	ErrChatNotFound    = errors.New("chat not found")
+	ErrChatExists      = errors.New("chat already exists")
+	ErrDirectChat      = errors.New("members of a direct chat cannot change")
+	ErrUserNotInChat   = errors.New("user is not a member of the chat")
+	ErrMessageNotFound = errors.New("message not found")
+	ErrMessageDeleted  = errors.New("message has been deleted")
+	ErrNotMessageOwner = errors.New("only the author can change a message")
+	ErrNotAllowed      = errors.New("your role in the chat does not allow this")
+	ErrUserBanned      = errors.New("user is banned from the chat")
+	ErrAlreadyMember   = errors.New("user is already a member of the chat")
+
+	ErrInvalidCredentials = errors.New("invalid user id or password")
+	ErrUnauthenticated    = errors.New("missing, invalid or expired token")
+	ErrTokenNotFound      = errors.New("token not found")
+
+	// ErrDuplicateMessage comes with the message stored earlier under the
+	// same client ID
+	ErrDuplicateMessage = errors.New("message was sent already")
+)
+
+var (
+	// ErrInvalidInput is wrapped by errors about a request that can never
+	// succeed as made, e.g. an empty field
+	ErrInvalidInput = errors.New("invalid input")
+	ErrUserExists   = errors.New("user already exists")
+	// ErrClientIDReused is a message sent with the client ID of an earlier
+	// one that it does not match, so it cannot be a retry of it
+	ErrClientIDReused = errors.New("client id was used for a different message")
+	// ErrChatChanged is an update to a chat that was updated by someone else
+	// since it was read
+	ErrChatChanged = errors.New("chat was changed by someone else")
 )
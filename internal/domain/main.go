@@ -1,21 +1,253 @@
 package domain
 
-import "time"
+import (
+	"slices"
+	"time"
+)
 
 type User struct {
 	ID   string
 	Name string
+	// LastSeen is when the user last disconnected; nil if they never connected
+	LastSeen *time.Time `json:",omitempty" bson:"last_seen,omitempty"`
+	// PasswordHash is a bcrypt hash; users created before passwords existed
+	// have none and cannot log in until one is set
+	PasswordHash []byte `json:",omitempty" bson:"password_hash,omitempty"`
+}
+
+// AuthToken is an issued bearer token. Only a hash of the token is stored.
+type AuthToken struct {
+	Hash      string    `json:"hash" bson:"hash"`
+	UserID    string    `json:"user_id" bson:"user_id"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	ExpiresAt time.Time `json:"expires_at" bson:"expires_at"`
+}
+
+func (t AuthToken) Expired(now time.Time) bool {
+	return !now.Before(t.ExpiresAt)
+}
+
+// Presence tells whether a user is connected right now and, if not, when
+// they last were
+type Presence struct {
+	UserID   string     `json:"user_id"`
+	Online   bool       `json:"online"`
+	LastSeen *time.Time `json:"last_seen,omitempty"`
 }
 
 type Message struct {
-	ID        string    `json:"id" bson:"_id"`
-	From      string    `json:"from" bson:"from"`
-	ChatID    string    `json:"chat_id" bson:"chat_id"`
+	ID     string `json:"id" bson:"_id"`
+	From   string `json:"from" bson:"from"`
+	ChatID string `json:"chat_id" bson:"chat_id"`
+	// Seq orders the messages of a chat. It is assigned by the repository when
+	// the message is stored and increases with every message in the chat, by
+	// one unless a store failed after taking a Seq, which leaves a gap.
+	Seq       int64     `json:"seq" bson:"seq"`
+	Text      string    `json:"text" bson:"text"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+
+	// ClientID is the idempotency key the sender picked, if any. Sending
+	// again with the same key within ClientIDWindow gets this message back
+	// instead of storing a copy.
+	ClientID string `json:"client_id,omitempty" bson:"client_id,omitempty"`
+
+	// ReplyTo is the message this one answers and ThreadRoot the message
+	// that started the thread; both are empty for messages outside threads
+	ReplyTo    string `json:"reply_to,omitempty" bson:"reply_to,omitempty"`
+	ThreadRoot string `json:"thread_root,omitempty" bson:"thread_root,omitempty"`
+	// ReplyCount is the number of replies in the thread this message started
+	ReplyCount int `json:"reply_count,omitempty" bson:"reply_count,omitempty"`
+
+	EditedAt *time.Time `json:"edited_at,omitempty" bson:"edited_at,omitempty"`
+	// DeletedAt marks a tombstone: the message keeps its place in the chat
+	// but its text and revisions are gone
+	DeletedAt *time.Time `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+	// Revisions holds the earlier versions of Text, oldest first
+	Revisions []MessageRevision `json:"revisions,omitempty" bson:"revisions,omitempty"`
+	// Reactions holds at most one entry per user and emoji, in the order they were added
+	Reactions []Reaction `json:"reactions,omitempty" bson:"reactions,omitempty"`
+}
+
+type Reaction struct {
+	UserID string `json:"user_id" bson:"user_id"`
+	Emoji  string `json:"emoji" bson:"emoji"`
+}
+
+// ReactionCount aggregates the reactions to a message with the same emoji
+type ReactionCount struct {
+	Emoji   string   `json:"emoji"`
+	Count   int      `json:"count"`
+	UserIDs []string `json:"user_ids"`
+}
+
+// MessageRevision is a version of a message's text and when it was written
+type MessageRevision struct {
 	Text      string    `json:"text" bson:"text"`
 	CreatedAt time.Time `json:"created_at" bson:"created_at"`
 }
 
+func (m Message) Deleted() bool {
+	return m.DeletedAt != nil
+}
+
+// ClientIDWindow is how long a ClientID keeps guarding against duplicates
+const ClientIDWindow = 24 * time.Hour
+
+// ClientKey identifies the message among those sent with a ClientID; it is
+// empty for messages sent without one. Client IDs are only unique per sender.
+func (m Message) ClientKey() string {
+	if m.ClientID == "" {
+		return ""
+	}
+	return m.From + "\x00" + m.ClientID
+}
+
+// ReactionCounts groups the message's reactions by emoji, in the order each
+// emoji was first used
+func (m Message) ReactionCounts() []ReactionCount {
+	var counts []ReactionCount
+	index := make(map[string]int)
+	for _, r := range m.Reactions {
+		i, ok := index[r.Emoji]
+		if !ok {
+			i = len(counts)
+			index[r.Emoji] = i
+			counts = append(counts, ReactionCount{Emoji: r.Emoji})
+		}
+		counts[i].Count++
+		counts[i].UserIDs = append(counts[i].UserIDs, r.UserID)
+	}
+	return counts
+}
+
+// MessagePage selects a window of a chat's history. Before and After are
+// message IDs used as cursors: Before returns the messages right before that
+// message, After the ones right after it, and neither returns the latest ones.
+// AfterSeq works like After but takes a Seq, so a client can catch up from the
+// last position it saw; it is a pointer because 0 is a position too, the one
+// before the first message. Results are always ordered by Seq.
+type MessagePage struct {
+	Before   string
+	After    string
+	AfterSeq *int64
+	Limit    int
+}
+
+const (
+	DefaultMessagePageSize = 50
+	MaxMessagePageSize     = 200
+)
+
+// Size is the number of messages the page holds at most: Limit, or
+// DefaultMessagePageSize if it is zero, capped at MaxMessagePageSize. A page
+// with fewer messages is the last one in its direction.
+func (p MessagePage) Size() int {
+	if p.Limit == 0 {
+		return DefaultMessagePageSize
+	}
+	return min(p.Limit, MaxMessagePageSize)
+}
+
+// MessageSearch selects messages for a full-text search. Zero fields do not
+// filter. Results are ordered newest first.
+type MessageSearch struct {
+	// Text holds the words that must all appear in a message
+	Text    string
+	ChatIDs []string
+	From    string
+	// Before and After bound CreatedAt; Before is exclusive, After inclusive
+	Before time.Time
+	After  time.Time
+	Limit  int
+}
+
 type Chat struct {
-	ID      string
-	Members []string
+	ID          string
+	Type        ChatType
+	Name        string
+	Description string
+	CreatedAt   time.Time `bson:"created_at"`
+	CreatedBy   string    `bson:"created_by"`
+	Members     []string
+	// Owner and Admins are members with extra rights; see RoleOf
+	Owner  string
+	Admins []string
+	// Banned users have been kicked and cannot be added back until unbanned
+	Banned []string
+	// DirectKey is only set on direct chats and is unique among them
+	DirectKey string `json:",omitempty" bson:"direct_key,omitempty"`
+	// Version counts the updates to the chat, so an update based on an
+	// outdated copy can be refused
+	Version int64 `json:",omitempty" bson:"version,omitempty"`
+}
+
+// UserChat is a chat as seen by one of its members
+type UserChat struct {
+	Chat        `bson:",inline"`
+	UnreadCount int
+}
+
+// ReadReceipt is how far a user has read a chat: every message up to and
+// including Seq
+type ReadReceipt struct {
+	UserID string    `json:"user_id" bson:"user_id"`
+	ChatID string    `json:"chat_id" bson:"chat_id"`
+	Seq    int64     `json:"seq" bson:"seq"`
+	ReadAt time.Time `json:"read_at" bson:"read_at"`
+}
+
+type ChatType string
+
+const (
+	ChatTypeGroup  ChatType = "group"
+	ChatTypeDirect ChatType = "direct"
+)
+
+// IsDirect reports whether the chat is a 1:1 conversation. Chats created
+// before chat types existed count as groups.
+func (c Chat) IsDirect() bool {
+	return c.Type == ChatTypeDirect
+}
+
+// DirectChatKey identifies the direct chat between two users, whichever of
+// them started it
+func DirectChatKey(userA, userB string) string {
+	if userB < userA {
+		userA, userB = userB, userA
+	}
+	return userA + "\x00" + userB
+}
+
+type ChatRole string
+
+const (
+	RoleOwner  ChatRole = "owner"
+	RoleAdmin  ChatRole = "admin"
+	RoleMember ChatRole = "member"
+)
+
+// RoleOf returns the role of userID in the chat, or "" if they are not a member
+func (c Chat) RoleOf(userID string) ChatRole {
+	switch {
+	case !slices.Contains(c.Members, userID):
+		return ""
+	case c.Owner == userID:
+		return RoleOwner
+	case slices.Contains(c.Admins, userID):
+		return RoleAdmin
+	default:
+		return RoleMember
+	}
+}
+
+// CanManage reports whether role may add, remove and ban members with role target
+func (role ChatRole) CanManage(target ChatRole) bool {
+	switch role {
+	case RoleOwner:
+		return target != RoleOwner
+	case RoleAdmin:
+		return target == RoleMember || target == ""
+	default:
+		return false
+	}
 }
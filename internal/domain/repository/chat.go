@@ -1,9 +1,18 @@
 package repository
 
-import "cligram/internal/domain"
+import (
+	"cligram/internal/domain"
+	"context"
+)
 
 type ChatRepository interface {
-	Create(chat domain.Chat) error
-	GetByID(id string) (domain.Chat, error)
-	ListByUser(userID string) ([]domain.Chat, error)
+	Create(ctx context.Context, chat domain.Chat) error
+	GetByID(ctx context.Context, id string) (domain.Chat, error)
+	// GetDirect returns the direct chat with the given domain.DirectChatKey
+	GetDirect(ctx context.Context, key string) (domain.Chat, error)
+	// Update replaces a stored chat with chat, one Version later, if the
+	// stored chat still has chat.Version; otherwise it returns
+	// domain.ErrChatChanged
+	Update(ctx context.Context, chat domain.Chat) error
+	ListByUser(ctx context.Context, userID string) ([]domain.Chat, error)
 }
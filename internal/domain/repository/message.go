@@ -1,8 +1,37 @@
 package repository
 
-import "cligram/internal/domain"
+import (
+	"cligram/internal/domain"
+	"context"
+	"time"
+)
 
 type MessageRepository interface {
-	Create(message domain.Message) error
-	ListByChat(chatID string) ([]domain.Message, error)
+	// Create assigns the message its sequence number and returns it as stored.
+	// If the sender stored a message with the same ClientID within
+	// domain.ClientIDWindow, that message is returned with
+	// domain.ErrDuplicateMessage instead.
+	Create(ctx context.Context, message domain.Message) (domain.Message, error)
+	GetByID(ctx context.Context, id string) (domain.Message, error)
+	// CountReply increments the ReplyCount of a thread root, deleted or not,
+	// and returns the updated root
+	CountReply(ctx context.Context, rootID string) (domain.Message, error)
+	ListByChat(ctx context.Context, chatID string, page domain.MessagePage) ([]domain.Message, error)
+	// ListThread returns the root message followed by its replies, ordered by Seq
+	ListThread(ctx context.Context, rootID string) ([]domain.Message, error)
+	// Edit replaces the text of a message that is not deleted, keeping the
+	// previous text as a revision, and returns the updated message
+	Edit(ctx context.Context, id string, text string, at time.Time) (domain.Message, error)
+	// Delete turns a message into a tombstone and returns it
+	Delete(ctx context.Context, id string, at time.Time) (domain.Message, error)
+	// AddReaction adds a reaction unless the user already reacted with that
+	// emoji, and returns the updated message and whether it changed
+	AddReaction(ctx context.Context, id string, reaction domain.Reaction) (msg domain.Message, changed bool, err error)
+	// RemoveReaction removes a reaction if present, and returns the updated
+	// message and whether it changed
+	RemoveReaction(ctx context.Context, id string, reaction domain.Reaction) (msg domain.Message, changed bool, err error)
+	Search(ctx context.Context, query domain.MessageSearch) ([]domain.Message, error)
+	// CountUnread counts the messages in a chat after afterSeq that are
+	// neither deleted nor written by userID
+	CountUnread(ctx context.Context, chatID, userID string, afterSeq int64) (int, error)
 }
@@ -0,0 +1,15 @@
+package repository
+
+import (
+	"cligram/internal/domain"
+	"context"
+)
+
+type ReadReceiptRepository interface {
+	// Advance moves the read position of receipt.UserID in receipt.ChatID
+	// forward to receipt.Seq. It reports false and changes nothing if the
+	// user has already read that far.
+	Advance(ctx context.Context, receipt domain.ReadReceipt) (bool, error)
+	ListByChat(ctx context.Context, chatID string) ([]domain.ReadReceipt, error)
+	ListByUser(ctx context.Context, userID string) ([]domain.ReadReceipt, error)
+}
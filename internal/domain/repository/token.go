@@ -0,0 +1,14 @@
+package repository
+
+import (
+	"cligram/internal/domain"
+	"context"
+	"time"
+)
+
+type TokenRepository interface {
+	Create(ctx context.Context, token domain.AuthToken) error
+	GetByHash(ctx context.Context, hash string) (domain.AuthToken, error)
+	// Revoke makes the token expire at the given time
+	Revoke(ctx context.Context, hash string, at time.Time) error
+}
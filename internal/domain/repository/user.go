@@ -1,8 +1,14 @@
 package repository
 
-import "cligram/internal/domain"
+import (
+	"cligram/internal/domain"
+	"context"
+	"time"
+)
 
 type UserRepository interface {
-	Create(user domain.User) error
-	GetByID(id string) (domain.User, error)
+	Create(ctx context.Context, user domain.User) error
+	GetByID(ctx context.Context, id string) (domain.User, error)
+	SetLastSeen(ctx context.Context, id string, at time.Time) error
+	SetPasswordHash(ctx context.Context, id string, hash []byte) error
 }
@@ -0,0 +1,183 @@
+package filestore
+
+import (
+	"bufio"
+	"bytes"
+	"cligram/internal/memory"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// DB is a single-file storage engine for cligram. The data file is an
+// append-only journal of JSON records, one per line; on Open it is replayed
+// into a memory.Store (which keeps the lookup indexes) and compacted. Every
+// write is fsynced before it becomes visible, so an acknowledged write
+// survives a crash.
+//
+// A data file must only be opened by one process at a time.
+type DB struct {
+	path  string
+	store *memory.Store
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// Open loads the data file at path, creating it if it does not exist
+func Open(path string) (*DB, error) {
+	store := memory.NewStore()
+
+	recs, err := readJournal(path)
+	if err != nil {
+		return nil, err
+	}
+	store.Load(recs...)
+
+	// rewrite the file with only the live state so the journal does not grow
+	// without bound across restarts
+	if err := writeSnapshot(path, store.Records()); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	db := &DB{path: path, store: store, file: f}
+	store.SetJournal(db)
+	return db, nil
+}
+
+// Store returns the store to build repositories on, e.g. memory.NewUserRepo(db.Store())
+func (db *DB) Store() *memory.Store {
+	return db.store
+}
+
+// Append implements memory.Journal
+func (db *DB) Append(rec memory.Record) error {
+	return db.appendRecords(rec)
+}
+
+// Import writes recs to the file with a single fsync and loads them into the
+// store. It is meant for bulk loads such as migrating from MongoDB.
+func (db *DB) Import(recs []memory.Record) error {
+	if err := db.appendRecords(recs...); err != nil {
+		return err
+	}
+	db.store.Load(recs...)
+	return nil
+}
+
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.file == nil {
+		return nil
+	}
+	err := db.file.Close()
+	db.file = nil
+	return err
+}
+
+func (db *DB) appendRecords(recs ...memory.Record) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, rec := range recs {
+		if err := enc.Encode(rec); err != nil {
+			return fmt.Errorf("encode record: %w", err)
+		}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.file == nil {
+		return errors.New("data file is closed")
+	}
+	if _, err := db.file.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("write %s: %w", db.path, err)
+	}
+	if err := db.file.Sync(); err != nil {
+		return fmt.Errorf("sync %s: %w", db.path, err)
+	}
+	return nil
+}
+
+func readJournal(path string) ([]memory.Record, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var recs []memory.Record
+	r := bufio.NewReader(f)
+	for line := 1; ; line++ {
+		data, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// a final line without a newline is a write that was cut short
+			// by a crash; it was never acknowledged, so drop it
+			return recs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var rec memory.Record
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return nil, fmt.Errorf("%s:%d: corrupt record: %w", path, line, err)
+		}
+		recs = append(recs, rec)
+	}
+}
+
+// writeSnapshot atomically replaces the file at path with recs
+func writeSnapshot(path string, recs []memory.Record) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, rec := range recs {
+		if err := enc.Encode(rec); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	// make the rename itself durable
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,139 @@
+package filestore_test
+
+import (
+	"cligram/internal/domain"
+	"cligram/internal/filestore"
+	"cligram/internal/memory"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fill stores a user, a chat and two messages in db and closes it
+func fill(t *testing.T, path string) {
+	t.Helper()
+	ctx := context.Background()
+
+	db, err := filestore.Open(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+
+	users := memory.NewUserRepo(db.Store())
+	for _, id := range []string{"alice", "bob"} {
+		if err := users.Create(ctx, domain.User{ID: id, Name: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	chat := domain.Chat{ID: "g", Type: domain.ChatTypeGroup, Members: []string{"alice", "bob"}, Owner: "alice"}
+	if err := memory.NewChatRepo(db.Store()).Create(ctx, chat); err != nil {
+		t.Fatal(err)
+	}
+	messages := memory.NewMessageRepo(db.Store())
+	for _, text := range []string{"one", "two"} {
+		msg := domain.Message{ID: text, From: "alice", ChatID: "g", Text: text, CreatedAt: time.Now()}
+		if _, err := messages.Create(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	tests := []struct {
+		name string
+		// damage changes the data file after it was filled
+		damage  func(t *testing.T, path string)
+		wantErr bool
+	}{
+		{name: "clean journal"},
+		{
+			name: "truncated last record",
+			damage: func(t *testing.T, path string) {
+				appendTo(t, path, `{"message":{"id":"three","chat_id":"g","te`)
+			},
+		},
+		{
+			name: "corrupt record",
+			damage: func(t *testing.T, path string) {
+				appendTo(t, path, "not json\n")
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			path := filepath.Join(t.TempDir(), "cligram.db")
+			fill(t, path)
+			if tt.damage != nil {
+				tt.damage(t, path)
+			}
+
+			db, err := filestore.Open(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want one: %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer db.Close()
+
+			if _, err := memory.NewUserRepo(db.Store()).GetByID(ctx, "bob"); err != nil {
+				t.Errorf("bob: %v", err)
+			}
+			msgs, err := memory.NewMessageRepo(db.Store()).ListByChat(ctx, "g", domain.MessagePage{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var texts []string
+			for _, m := range msgs {
+				texts = append(texts, m.Text)
+			}
+			if got := strings.Join(texts, ","); got != "one,two" {
+				t.Fatalf("got messages %s, want one,two", got)
+			}
+			if msgs[1].Seq != 2 {
+				t.Errorf("got Seq %d for the second message, want 2", msgs[1].Seq)
+			}
+
+			// the snapshot written on Open holds whole records only
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasSuffix(string(data), "\n") {
+				t.Error("data file does not end with a whole record")
+			}
+		})
+	}
+}
+
+func TestOpenMissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cligram.db")
+	db, err := filestore.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("data file was not created: %v", err)
+	}
+}
+
+func appendTo(t *testing.T, path, data string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,78 @@
+package memory
+
+import (
+	"cligram/internal/domain"
+	"context"
+	"fmt"
+	"strings"
+)
+
+type ChatRepo struct {
+	store *Store
+}
+
+func NewChatRepo(store *Store) *ChatRepo {
+	return &ChatRepo{store: store}
+}
+
+// Create implements repository.ChatRepository
+func (r *ChatRepo) Create(_ context.Context, c domain.Chat) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if _, ok := r.store.chats[c.ID]; ok {
+		return fmt.Errorf("%w: %s", domain.ErrChatExists, c.ID)
+	}
+	if _, ok := r.store.directChats[c.DirectKey]; ok && c.DirectKey != "" {
+		return fmt.Errorf("%w: direct chat between %s", domain.ErrChatExists, strings.Join(c.Members, " and "))
+	}
+	return r.store.write(Record{Chat: &c})
+}
+
+func (r *ChatRepo) GetByID(_ context.Context, id string) (domain.Chat, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	c, ok := r.store.chats[id]
+	if !ok {
+		return domain.Chat{}, domain.ErrChatNotFound
+	}
+	return cloneChat(c), nil
+}
+
+func (r *ChatRepo) GetDirect(_ context.Context, key string) (domain.Chat, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	id, ok := r.store.directChats[key]
+	if !ok {
+		return domain.Chat{}, domain.ErrChatNotFound
+	}
+	return cloneChat(r.store.chats[id]), nil
+}
+
+func (r *ChatRepo) Update(_ context.Context, c domain.Chat) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	stored, ok := r.store.chats[c.ID]
+	if !ok {
+		return domain.ErrChatNotFound
+	}
+	if stored.Version != c.Version {
+		return domain.ErrChatChanged
+	}
+	c.Version++
+	return r.store.write(Record{Chat: &c})
+}
+
+func (r *ChatRepo) ListByUser(_ context.Context, userID string) ([]domain.Chat, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	var chats []domain.Chat
+	for _, chatID := range r.store.chatsByUser[userID] {
+		chats = append(chats, cloneChat(r.store.chats[chatID]))
+	}
+	return chats, nil
+}
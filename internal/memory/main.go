@@ -0,0 +1,261 @@
+package memory
+
+import (
+	"cligram/internal/domain"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Store holds the state shared by the in-memory repositories. It is safe for
+// concurrent use and is meant for development, demos and tests where running
+// MongoDB is not worth the trouble.
+type Store struct {
+	mu      sync.RWMutex
+	journal Journal
+
+	users    map[string]domain.User
+	chats    map[string]domain.Chat
+	messages map[string]domain.Message
+
+	chatsByUser    map[string][]string // userID -> chat IDs, in creation order
+	directChats    map[string]string   // domain.DirectChatKey -> chat ID
+	messagesByChat map[string][]string // chatID -> message IDs, ordered by Seq
+	lastSeq        map[string]int64    // chatID -> highest Seq in the chat
+	threads        map[string][]string // thread root ID -> reply IDs, ordered by Seq
+	wordIndex      map[string]idSet    // lowercased word -> IDs of messages containing it
+	clientKeys     map[string]string   // domain.Message.ClientKey -> message ID, for recent messages
+
+	// clientKeysPruned is when expired client keys were last dropped
+	clientKeysPruned time.Time
+	// tokensPruned is when expired tokens were last dropped
+	tokensPruned time.Time
+
+	receipts map[string]map[string]domain.ReadReceipt // chatID -> userID -> receipt
+	tokens   map[string]domain.AuthToken              // token hash -> token
+}
+
+type idSet map[string]struct{}
+
+// Record is a single write to the store. Exactly one of the entity fields is set.
+type Record struct {
+	User    *domain.User        `json:"user,omitempty"`
+	Chat    *domain.Chat        `json:"chat,omitempty"`
+	Message *domain.Message     `json:"message,omitempty"`
+	Receipt *domain.ReadReceipt `json:"receipt,omitempty"`
+	Token   *domain.AuthToken   `json:"token,omitempty"`
+}
+
+// Journal is handed every record before it is applied, so a write only becomes
+// visible once the journal has accepted it. This is how the store is made durable.
+type Journal interface {
+	Append(rec Record) error
+}
+
+// NewStore creates an empty store
+func NewStore() *Store {
+	return &Store{
+		users:          make(map[string]domain.User),
+		chats:          make(map[string]domain.Chat),
+		messages:       make(map[string]domain.Message),
+		chatsByUser:    make(map[string][]string),
+		directChats:    make(map[string]string),
+		messagesByChat: make(map[string][]string),
+		lastSeq:        make(map[string]int64),
+		threads:        make(map[string][]string),
+		wordIndex:      make(map[string]idSet),
+		clientKeys:     make(map[string]string),
+		receipts:       make(map[string]map[string]domain.ReadReceipt),
+		tokens:         make(map[string]domain.AuthToken),
+	}
+}
+
+// SetJournal makes every following write go through j first
+func (s *Store) SetJournal(j Journal) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.journal = j
+}
+
+// Load applies records without journaling them, e.g. when replaying a data file
+func (s *Store) Load(recs ...Record) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, rec := range recs {
+		s.apply(rec)
+	}
+}
+
+// Records returns the current state as a minimal list of records that
+// recreates it when loaded into an empty store. Expired tokens are left out.
+func (s *Store) Records() []Record {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	recs := make([]Record, 0, len(s.users)+len(s.chats)+len(s.messages))
+	for _, id := range slices.Sorted(maps.Keys(s.users)) {
+		u := s.users[id]
+		recs = append(recs, Record{User: &u})
+	}
+	for _, id := range slices.Sorted(maps.Keys(s.chats)) {
+		c := cloneChat(s.chats[id])
+		recs = append(recs, Record{Chat: &c})
+	}
+	for _, chatID := range slices.Sorted(maps.Keys(s.messagesByChat)) {
+		for _, id := range s.messagesByChat[chatID] {
+			m := s.messages[id]
+			recs = append(recs, Record{Message: &m})
+		}
+	}
+	for _, chatID := range slices.Sorted(maps.Keys(s.receipts)) {
+		for _, userID := range slices.Sorted(maps.Keys(s.receipts[chatID])) {
+			r := s.receipts[chatID][userID]
+			recs = append(recs, Record{Receipt: &r})
+		}
+	}
+	now := time.Now()
+	for _, hash := range slices.Sorted(maps.Keys(s.tokens)) {
+		if t := s.tokens[hash]; !t.Expired(now) {
+			recs = append(recs, Record{Token: &t})
+		}
+	}
+	return recs
+}
+
+// write journals rec and applies it. Callers must hold s.mu.
+func (s *Store) write(rec Record) error {
+	if s.journal != nil {
+		if err := s.journal.Append(rec); err != nil {
+			return err
+		}
+	}
+	s.apply(rec)
+	return nil
+}
+
+// apply stores the entity in rec and keeps the indexes in sync. Callers must hold s.mu.
+func (s *Store) apply(rec Record) {
+	switch {
+	case rec.User != nil:
+		s.users[rec.User.ID] = *rec.User
+
+	case rec.Chat != nil:
+		c := cloneChat(*rec.Chat)
+		old := s.chats[c.ID]
+		for _, userID := range old.Members {
+			if !slices.Contains(c.Members, userID) {
+				s.chatsByUser[userID] = slices.DeleteFunc(s.chatsByUser[userID], func(id string) bool { return id == c.ID })
+			}
+		}
+		for _, userID := range c.Members {
+			if !slices.Contains(old.Members, userID) {
+				s.chatsByUser[userID] = append(s.chatsByUser[userID], c.ID)
+			}
+		}
+		if c.DirectKey != "" {
+			s.directChats[c.DirectKey] = c.ID
+		}
+		s.chats[c.ID] = c
+
+	case rec.Message != nil:
+		m := *rec.Message
+		if old, exists := s.messages[m.ID]; exists {
+			s.unindexWords(old)
+		} else {
+			s.messagesByChat[m.ChatID] = append(s.messagesByChat[m.ChatID], m.ID)
+			if m.ThreadRoot != "" {
+				s.threads[m.ThreadRoot] = append(s.threads[m.ThreadRoot], m.ID)
+			}
+		}
+		s.messages[m.ID] = m
+		s.indexWords(m)
+		if key := m.ClientKey(); key != "" && time.Since(m.CreatedAt) < domain.ClientIDWindow {
+			s.clientKeys[key] = m.ID
+		}
+		s.lastSeq[m.ChatID] = max(s.lastSeq[m.ChatID], m.Seq)
+
+	case rec.Receipt != nil:
+		r := *rec.Receipt
+		if s.receipts[r.ChatID] == nil {
+			s.receipts[r.ChatID] = make(map[string]domain.ReadReceipt)
+		}
+		s.receipts[r.ChatID][r.UserID] = r
+
+	case rec.Token != nil:
+		s.tokens[rec.Token.Hash] = *rec.Token
+	}
+}
+
+// clientKeyPruneInterval is how often Create drops expired client keys
+const clientKeyPruneInterval = time.Hour
+
+// pruneClientKeys drops the client keys of messages older than
+// domain.ClientIDWindow, at most once per clientKeyPruneInterval since it
+// walks all of them
+func (s *Store) pruneClientKeys(now time.Time) {
+	if now.Sub(s.clientKeysPruned) < clientKeyPruneInterval {
+		return
+	}
+	s.clientKeysPruned = now
+	for key, id := range s.clientKeys {
+		if now.Sub(s.messages[id].CreatedAt) >= domain.ClientIDWindow {
+			delete(s.clientKeys, key)
+		}
+	}
+}
+
+// tokenPruneInterval is how often Create drops expired tokens
+const tokenPruneInterval = time.Hour
+
+// pruneTokens drops expired and revoked tokens, at most once per
+// tokenPruneInterval, as the TTL index does in MongoDB. They are not
+// written to the journal; Records leaves them out of the next snapshot.
+func (s *Store) pruneTokens(now time.Time) {
+	if now.Sub(s.tokensPruned) < tokenPruneInterval {
+		return
+	}
+	s.tokensPruned = now
+	for hash, t := range s.tokens {
+		if t.Expired(now) {
+			delete(s.tokens, hash)
+		}
+	}
+}
+
+func (s *Store) indexWords(m domain.Message) {
+	for _, w := range words(m.Text) {
+		if s.wordIndex[w] == nil {
+			s.wordIndex[w] = make(idSet)
+		}
+		s.wordIndex[w][m.ID] = struct{}{}
+	}
+}
+
+func (s *Store) unindexWords(m domain.Message) {
+	for _, w := range words(m.Text) {
+		delete(s.wordIndex[w], m.ID)
+		if len(s.wordIndex[w]) == 0 {
+			delete(s.wordIndex, w)
+		}
+	}
+}
+
+// words splits text into its distinct lowercased words
+func words(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	slices.Sort(fields)
+	return slices.Compact(fields)
+}
+
+// cloneChat makes sure callers never share the slices of a chat with the store
+func cloneChat(c domain.Chat) domain.Chat {
+	c.Members = slices.Clone(c.Members)
+	c.Admins = slices.Clone(c.Admins)
+	c.Banned = slices.Clone(c.Banned)
+	return c
+}
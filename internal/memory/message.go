@@ -0,0 +1,278 @@
+package memory
+
+import (
+	"cligram/internal/domain"
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+type MessageRepo struct {
+	store *Store
+}
+
+func NewMessageRepo(store *Store) *MessageRepo {
+	return &MessageRepo{store: store}
+}
+
+// Create implements repository.MessageRepository
+func (r *MessageRepo) Create(_ context.Context, m domain.Message) (domain.Message, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if _, ok := r.store.messages[m.ID]; ok {
+		return domain.Message{}, fmt.Errorf("message with id %s already exists", m.ID)
+	}
+	r.store.pruneClientKeys(time.Now())
+	if id, ok := r.store.clientKeys[m.ClientKey()]; ok {
+		if sent := r.store.messages[id]; time.Since(sent.CreatedAt) < domain.ClientIDWindow {
+			return sent, domain.ErrDuplicateMessage
+		}
+	}
+
+	m.Seq = r.store.lastSeq[m.ChatID] + 1
+	if err := r.store.write(Record{Message: &m}); err != nil {
+		return domain.Message{}, err
+	}
+	return m, nil
+}
+
+// CountReply implements repository.MessageRepository
+func (r *MessageRepo) CountReply(_ context.Context, rootID string) (domain.Message, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	root, ok := r.store.messages[rootID]
+	if !ok {
+		return domain.Message{}, domain.ErrMessageNotFound
+	}
+	root.ReplyCount++
+	if err := r.store.write(Record{Message: &root}); err != nil {
+		return domain.Message{}, err
+	}
+	return root, nil
+}
+
+func (r *MessageRepo) GetByID(_ context.Context, id string) (domain.Message, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	m, ok := r.store.messages[id]
+	if !ok {
+		return domain.Message{}, domain.ErrMessageNotFound
+	}
+	return m, nil
+}
+
+// Edit implements repository.MessageRepository
+func (r *MessageRepo) Edit(_ context.Context, id string, text string, at time.Time) (domain.Message, error) {
+	return r.updateLive(id, func(m *domain.Message) {
+		written := m.CreatedAt
+		if m.EditedAt != nil {
+			written = *m.EditedAt
+		}
+		m.Revisions = append(slices.Clone(m.Revisions), domain.MessageRevision{Text: m.Text, CreatedAt: written})
+		m.Text = text
+		m.EditedAt = &at
+	})
+}
+
+// Delete implements repository.MessageRepository
+func (r *MessageRepo) Delete(_ context.Context, id string, at time.Time) (domain.Message, error) {
+	return r.updateLive(id, func(m *domain.Message) {
+		m.Text = ""
+		m.Revisions = nil
+		m.Reactions = nil
+		m.DeletedAt = &at
+	})
+}
+
+// AddReaction implements repository.MessageRepository
+func (r *MessageRepo) AddReaction(_ context.Context, id string, reaction domain.Reaction) (domain.Message, bool, error) {
+	return r.updateLiveIf(id, func(m *domain.Message) bool {
+		if slices.Contains(m.Reactions, reaction) {
+			return false
+		}
+		m.Reactions = append(slices.Clone(m.Reactions), reaction)
+		return true
+	})
+}
+
+// RemoveReaction implements repository.MessageRepository
+func (r *MessageRepo) RemoveReaction(_ context.Context, id string, reaction domain.Reaction) (domain.Message, bool, error) {
+	return r.updateLiveIf(id, func(m *domain.Message) bool {
+		if !slices.Contains(m.Reactions, reaction) {
+			return false
+		}
+		m.Reactions = slices.DeleteFunc(slices.Clone(m.Reactions), func(x domain.Reaction) bool {
+			return x == reaction
+		})
+		return true
+	})
+}
+
+// updateLive applies change to a copy of a message that has not been deleted
+// and stores the result
+func (r *MessageRepo) updateLive(id string, change func(m *domain.Message)) (domain.Message, error) {
+	m, _, err := r.updateLiveIf(id, func(m *domain.Message) bool {
+		change(m)
+		return true
+	})
+	return m, err
+}
+
+// updateLiveIf is updateLive for a change that may be a no-op: change
+// reports whether it changed anything, and nothing is stored if not
+func (r *MessageRepo) updateLiveIf(id string, change func(m *domain.Message) bool) (domain.Message, bool, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	m, ok := r.store.messages[id]
+	if !ok {
+		return domain.Message{}, false, domain.ErrMessageNotFound
+	}
+	if m.Deleted() {
+		return domain.Message{}, false, domain.ErrMessageDeleted
+	}
+
+	if !change(&m) {
+		return m, false, nil
+	}
+	if err := r.store.write(Record{Message: &m}); err != nil {
+		return domain.Message{}, false, err
+	}
+	return m, true, nil
+}
+
+// ListByChat returns one page of a chat's history, ordered by Seq
+func (r *MessageRepo) ListByChat(_ context.Context, chatID string, page domain.MessagePage) ([]domain.Message, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	ids := r.store.messagesByChat[chatID]
+
+	// [start, end) is the window of ids to return
+	start, end := 0, len(ids)
+	switch {
+	case page.Before != "":
+		end = slices.Index(ids, page.Before)
+		if end < 0 {
+			return nil, domain.ErrMessageNotFound
+		}
+	case page.After != "":
+		start = slices.Index(ids, page.After) + 1
+		if start == 0 {
+			return nil, domain.ErrMessageNotFound
+		}
+	case page.AfterSeq != nil:
+		start, _ = slices.BinarySearchFunc(ids, *page.AfterSeq+1, func(id string, seq int64) int {
+			return cmp.Compare(r.store.messages[id].Seq, seq)
+		})
+	}
+
+	if page.Limit > 0 && end-start > page.Limit {
+		if page.After != "" || page.AfterSeq != nil {
+			end = start + page.Limit
+		} else {
+			start = end - page.Limit
+		}
+	}
+
+	var messages []domain.Message
+	for _, id := range ids[start:end] {
+		messages = append(messages, r.store.messages[id])
+	}
+	return messages, nil
+}
+
+func (r *MessageRepo) ListThread(_ context.Context, rootID string) ([]domain.Message, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	root, ok := r.store.messages[rootID]
+	if !ok {
+		return nil, domain.ErrMessageNotFound
+	}
+
+	messages := []domain.Message{root}
+	for _, id := range r.store.threads[rootID] {
+		messages = append(messages, r.store.messages[id])
+	}
+	return messages, nil
+}
+
+// Search matches whole words case-insensitively using the store's word index.
+// Words are not stemmed, unlike with the MongoDB text index: "cat" does not
+// find "cats".
+func (r *MessageRepo) Search(_ context.Context, query domain.MessageSearch) ([]domain.Message, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	var candidates []string
+	if terms := words(query.Text); len(terms) > 0 {
+		// walk the rarest word's postings and check the others against it
+		slices.SortFunc(terms, func(a, b string) int {
+			return len(r.store.wordIndex[a]) - len(r.store.wordIndex[b])
+		})
+	next:
+		for id := range r.store.wordIndex[terms[0]] {
+			for _, t := range terms[1:] {
+				if _, ok := r.store.wordIndex[t][id]; !ok {
+					continue next
+				}
+			}
+			candidates = append(candidates, id)
+		}
+	} else if query.ChatIDs != nil {
+		for _, chatID := range query.ChatIDs {
+			candidates = append(candidates, r.store.messagesByChat[chatID]...)
+		}
+	} else {
+		for id := range r.store.messages {
+			candidates = append(candidates, id)
+		}
+	}
+
+	var messages []domain.Message
+	for _, id := range candidates {
+		m := r.store.messages[id]
+		switch {
+		case m.Deleted(),
+			query.ChatIDs != nil && !slices.Contains(query.ChatIDs, m.ChatID),
+			query.From != "" && m.From != query.From,
+			!query.Before.IsZero() && !m.CreatedAt.Before(query.Before),
+			!query.After.IsZero() && m.CreatedAt.Before(query.After):
+			continue
+		}
+		messages = append(messages, m)
+	}
+
+	slices.SortFunc(messages, func(a, b domain.Message) int {
+		return cmp.Or(b.CreatedAt.Compare(a.CreatedAt), strings.Compare(b.ID, a.ID))
+	})
+	if query.Limit > 0 && len(messages) > query.Limit {
+		messages = messages[:query.Limit]
+	}
+	return messages, nil
+}
+
+func (r *MessageRepo) CountUnread(_ context.Context, chatID, userID string, afterSeq int64) (int, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	ids := r.store.messagesByChat[chatID]
+	start, _ := slices.BinarySearchFunc(ids, afterSeq+1, func(id string, seq int64) int {
+		return cmp.Compare(r.store.messages[id].Seq, seq)
+	})
+
+	count := 0
+	for _, id := range ids[start:] {
+		if m := r.store.messages[id]; m.From != userID && !m.Deleted() {
+			count++
+		}
+	}
+	return count, nil
+}
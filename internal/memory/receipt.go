@@ -0,0 +1,55 @@
+package memory
+
+import (
+	"cligram/internal/domain"
+	"context"
+	"maps"
+	"slices"
+)
+
+type ReadReceiptRepo struct {
+	store *Store
+}
+
+func NewReadReceiptRepo(store *Store) *ReadReceiptRepo {
+	return &ReadReceiptRepo{store: store}
+}
+
+// Advance implements repository.ReadReceiptRepository
+func (r *ReadReceiptRepo) Advance(_ context.Context, receipt domain.ReadReceipt) (bool, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if current, ok := r.store.receipts[receipt.ChatID][receipt.UserID]; ok && current.Seq >= receipt.Seq {
+		return false, nil
+	}
+	if err := r.store.write(Record{Receipt: &receipt}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *ReadReceiptRepo) ListByChat(_ context.Context, chatID string) ([]domain.ReadReceipt, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	receipts := r.store.receipts[chatID]
+	var list []domain.ReadReceipt
+	for _, userID := range slices.Sorted(maps.Keys(receipts)) {
+		list = append(list, receipts[userID])
+	}
+	return list, nil
+}
+
+func (r *ReadReceiptRepo) ListByUser(_ context.Context, userID string) ([]domain.ReadReceipt, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	var list []domain.ReadReceipt
+	for _, chatID := range slices.Sorted(maps.Keys(r.store.receipts)) {
+		if receipt, ok := r.store.receipts[chatID][userID]; ok {
+			list = append(list, receipt)
+		}
+	}
+	return list, nil
+}
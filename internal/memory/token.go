@@ -0,0 +1,46 @@
+package memory
+
+import (
+	"cligram/internal/domain"
+	"context"
+	"time"
+)
+
+type TokenRepo struct {
+	store *Store
+}
+
+func NewTokenRepo(store *Store) *TokenRepo {
+	return &TokenRepo{store: store}
+}
+
+// Create implements repository.TokenRepository
+func (r *TokenRepo) Create(_ context.Context, t domain.AuthToken) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	r.store.pruneTokens(time.Now())
+	return r.store.write(Record{Token: &t})
+}
+
+func (r *TokenRepo) GetByHash(_ context.Context, hash string) (domain.AuthToken, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	t, ok := r.store.tokens[hash]
+	if !ok {
+		return domain.AuthToken{}, domain.ErrTokenNotFound
+	}
+	return t, nil
+}
+
+func (r *TokenRepo) Revoke(_ context.Context, hash string, at time.Time) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	t, ok := r.store.tokens[hash]
+	if !ok {
+		return domain.ErrTokenNotFound
+	}
+	t.ExpiresAt = at
+	return r.store.write(Record{Token: &t})
+}
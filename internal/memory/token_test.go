@@ -0,0 +1,39 @@
+package memory
+
+import (
+	"cligram/internal/domain"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTokenRepoPrunesExpired(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore()
+	repo := NewTokenRepo(store)
+	now := time.Now()
+
+	for _, tok := range []domain.AuthToken{
+		{Hash: "expired", UserID: "alice", ExpiresAt: now.Add(-time.Minute)},
+		{Hash: "valid", UserID: "alice", ExpiresAt: now.Add(time.Hour)},
+	} {
+		if err := repo.Create(ctx, tok); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repo.Revoke(ctx, "valid", now); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first Create pruned; let the next one do it again
+	store.tokensPruned = time.Time{}
+	if err := repo.Create(ctx, domain.AuthToken{Hash: "new", UserID: "bob", ExpiresAt: now.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	for hash, wantKept := range map[string]bool{"expired": false, "valid": false, "new": true} {
+		if _, err := repo.GetByHash(ctx, hash); (err == nil) != wantKept {
+			t.Errorf("token %s: got error %v, want it kept: %t", hash, err, wantKept)
+		}
+	}
+}
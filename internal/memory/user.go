@@ -0,0 +1,62 @@
+package memory
+
+import (
+	"cligram/internal/domain"
+	"context"
+	"fmt"
+	"time"
+)
+
+type UserRepo struct {
+	store *Store
+}
+
+func NewUserRepo(store *Store) *UserRepo {
+	return &UserRepo{store: store}
+}
+
+// Create implements repository.UserRepository
+func (r *UserRepo) Create(_ context.Context, u domain.User) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if _, ok := r.store.users[u.ID]; ok {
+		return fmt.Errorf("%w: %s", domain.ErrUserExists, u.ID)
+	}
+	return r.store.write(Record{User: &u})
+}
+
+func (r *UserRepo) GetByID(_ context.Context, id string) (domain.User, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	u, ok := r.store.users[id]
+	if !ok {
+		return domain.User{}, domain.ErrUserNotFound
+	}
+	return u, nil
+}
+
+func (r *UserRepo) SetLastSeen(_ context.Context, id string, at time.Time) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	u, ok := r.store.users[id]
+	if !ok {
+		return domain.ErrUserNotFound
+	}
+	u.LastSeen = &at
+	return r.store.write(Record{User: &u})
+}
+
+func (r *UserRepo) SetPasswordHash(_ context.Context, id string, hash []byte) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	u, ok := r.store.users[id]
+	if !ok {
+		return domain.ErrUserNotFound
+	}
+	u.PasswordHash = hash
+	return r.store.write(Record{User: &u})
+}
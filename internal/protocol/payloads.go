@@ -0,0 +1,93 @@
+package protocol
+
+import "cligram/internal/domain"
+
+// ChatRef is the payload of requests about a single chat
+type ChatRef struct {
+	ChatID string `json:"chat_id"`
+}
+
+// Send asks to store a message. ReplyTo makes it a reply, and the chat is
+// then taken from that message; ChatID may be left out, but must match it.
+// ClientID is an optional idempotency key: sending again with the same one
+// does not store the message twice.
+type Send struct {
+	ChatID   string `json:"chat_id,omitempty"`
+	Text     string `json:"text"`
+	ReplyTo  string `json:"reply_to,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
+}
+
+// Read marks a chat read up to MessageID
+type Read struct {
+	ChatID    string `json:"chat_id"`
+	MessageID string `json:"message_id"`
+}
+
+// Sync subscribes to chats and replays what was missed in them. Positions
+// maps chat IDs to the last message Seq seen; a chat at 0 only gets its latest
+// messages replayed, not all of its history.
+type Sync struct {
+	Positions map[string]int64 `json:"positions"`
+}
+
+// SyncResult acks a Sync. Failed holds an error for each chat that could not
+// be synced; the others were.
+type SyncResult struct {
+	Failed []Error `json:"failed,omitempty"`
+}
+
+// Connected introduces the connection. Resumed tells whether the session the
+// client asked for was resumed; if not the client should sync.
+type Connected struct {
+	Version      int    `json:"version"`
+	UserID       string `json:"user_id"`
+	ConnectionID string `json:"connection_id"`
+	ResumeToken  string `json:"resume_token"`
+	Resumed      bool   `json:"resumed,omitempty"`
+}
+
+// MessageEvent carries a message as stored, after it was sent, edited or
+// deleted
+type MessageEvent struct {
+	Message domain.Message `json:"message"`
+}
+
+// ReactionEvent tells that a reaction was added to or removed from Message
+type ReactionEvent struct {
+	Message  domain.Message  `json:"message"`
+	Reaction domain.Reaction `json:"reaction"`
+	Removed  bool            `json:"removed,omitempty"`
+}
+
+// MemberEvent tells about a change to the members of Chat, which is the chat
+// after the change
+type MemberEvent struct {
+	Chat   domain.Chat     `json:"chat"`
+	UserID string          `json:"user_id"`
+	By     string          `json:"by,omitempty"`
+	Banned bool            `json:"banned,omitempty"`
+	Role   domain.ChatRole `json:"role,omitempty"`
+}
+
+// Receipt tells how far a member has read a chat
+type Receipt struct {
+	Receipt domain.ReadReceipt `json:"receipt"`
+}
+
+// Typing tells that UserID started or stopped typing in ChatID
+type Typing struct {
+	ChatID string `json:"chat_id"`
+	UserID string `json:"user_id"`
+}
+
+// PresenceEvent tells that a contact came online or went offline
+type PresenceEvent struct {
+	Presence domain.Presence `json:"presence"`
+}
+
+// Missed carries messages stored while the client was away, oldest first
+type Missed struct {
+	ChatID   string           `json:"chat_id"`
+	Messages []domain.Message `json:"messages"`
+}
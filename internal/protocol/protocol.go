@@ -0,0 +1,151 @@
+// Package protocol defines the frames exchanged over the chat WebSocket. The
+// server and the CLI both use it, so the two cannot drift apart.
+//
+// Every frame is an Envelope whose Type says what its Payload holds. A client
+// request that carries a RequestID is answered with an ack or an error
+// carrying the same RequestID; requests without one are fire-and-forget, but
+// their failures are still reported. The version is negotiated as a
+// WebSocket subprotocol during the upgrade.
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Version is the newest protocol version this build speaks
+const Version = 1
+
+// subprotocolPrefix followed by the version names a protocol version in the
+// Sec-WebSocket-Protocol header, e.g. "cligram.v1"
+const subprotocolPrefix = "cligram.v"
+
+// Subprotocol returns the WebSocket subprotocol of a protocol version
+func Subprotocol(version int) string {
+	return fmt.Sprintf("%s%d", subprotocolPrefix, version)
+}
+
+// Subprotocols lists the subprotocols of the versions this build speaks,
+// newest first, as clients offer them
+func Subprotocols() []string {
+	return []string{Subprotocol(Version)}
+}
+
+// Negotiate picks the newest version this build speaks among the
+// subprotocols a client offered
+func Negotiate(offered []string) (version int, ok bool) {
+	for v := Version; v >= 1; v-- {
+		if slices.Contains(offered, Subprotocol(v)) {
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+// ParseSubprotocol returns the version of a subprotocol the server selected
+func ParseSubprotocol(name string) (version int, ok bool) {
+	rest, found := strings.CutPrefix(name, subprotocolPrefix)
+	if !found {
+		return 0, false
+	}
+	if _, err := fmt.Sscanf(rest, "%d", &version); err != nil || version < 1 {
+		return 0, false
+	}
+	return version, true
+}
+
+// Type tells what an envelope carries
+type Type string
+
+// Client requests
+const (
+	TypeSubscribe   Type = "subscribe"   // ChatRef
+	TypeUnsubscribe Type = "unsubscribe" // ChatRef
+	TypeSend        Type = "send"        // Send, acked with a MessageEvent
+	TypeTypingStart Type = "typing_start"
+	TypeTypingStop  Type = "typing_stop"
+	TypeRead        Type = "read" // Read
+	TypeSync        Type = "sync" // Sync, acked with a SyncResult once every chat was replayed
+)
+
+// Server events. TypeTypingStart, TypeTypingStop and TypeRead are also sent
+// by the server, with a Typing and a Receipt payload.
+const (
+	TypeConnected         Type = "connected" // Connected, always the first frame
+	TypeAck               Type = "ack"       // depends on the request
+	TypeError             Type = "error"     // no payload, see Envelope.Error
+	TypeMessage           Type = "message"   // MessageEvent
+	TypeMessageEdited     Type = "message_edited"
+	TypeMessageDeleted    Type = "message_deleted"
+	TypeThreadUpdated     Type = "thread_updated" // MessageEvent with the thread root
+	TypeReaction          Type = "reaction"       // ReactionEvent
+	TypeMemberAdded       Type = "member_added"   // MemberEvent
+	TypeMemberRemoved     Type = "member_removed"
+	TypeMemberRoleChanged Type = "member_role_changed"
+	TypePresence          Type = "presence" // PresenceEvent
+	TypeMissed            Type = "missed"   // Missed
+)
+
+// Envelope is a frame in either direction
+type Envelope struct {
+	Type Type `json:"type"`
+	// RequestID is picked by the client for a request and copied to the
+	// ack or error answering it
+	RequestID string          `json:"request_id,omitempty"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	Error     *Error          `json:"error,omitempty"`
+	// Seq numbers the events of a session, "connected" aside; a client
+	// resumes from the last one it received
+	Seq int64 `json:"seq,omitempty"`
+}
+
+// New builds an envelope around payload, which may be nil
+func New(t Type, payload any) Envelope {
+	env := Envelope{Type: t}
+	if payload != nil {
+		// payloads are plain structs, marshalling them cannot fail
+		env.Payload, _ = json.Marshal(payload)
+	}
+	return env
+}
+
+// Decode unmarshals the payload of env into v
+func (env Envelope) Decode(v any) error {
+	if len(env.Payload) == 0 {
+		return fmt.Errorf("%s frame has no payload", env.Type)
+	}
+	if err := json.Unmarshal(env.Payload, v); err != nil {
+		return fmt.Errorf("invalid %s payload: %w", env.Type, err)
+	}
+	return nil
+}
+
+// ErrorCode classifies an error so clients can react without parsing the
+// message
+type ErrorCode string
+
+const (
+	CodeBadRequest      ErrorCode = "bad_request"
+	CodeUnknownType     ErrorCode = "unknown_type"
+	CodeUnauthenticated ErrorCode = "unauthenticated"
+	CodeForbidden       ErrorCode = "forbidden"
+	CodeNotFound        ErrorCode = "not_found"
+	CodeConflict        ErrorCode = "conflict"
+	CodeGone            ErrorCode = "gone"
+	// CodeInternal is a server fault; the request may succeed if retried
+	CodeInternal ErrorCode = "internal"
+)
+
+// Error is a refused request
+type Error struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+	// ChatID is the chat the request was about, if any
+	ChatID string `json:"chat_id,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}